@@ -71,6 +71,8 @@ func (g *Channel) invokeMethodV1(ctx context.Context, req *invokev1.InvokeMethod
 	}
 
 	clientV1 := runtimev1pb.NewAppCallbackClient(g.client)
+	// Internal Dapr headers and context values are never delivered to the app
+	req.StripInternalHeaders()
 	grpcMetadata := invokev1.InternalMetadataToGrpcMetadata(ctx, req.Metadata(), true)
 	// Prepare gRPC Metadata
 	ctx = metadata.NewOutgoingContext(context.Background(), grpcMetadata)
@@ -87,6 +87,8 @@ func (h *Channel) InvokeMethod(ctx context.Context, req *invokev1.InvokeMethodRe
 }
 
 func (h *Channel) invokeMethodV1(ctx context.Context, req *invokev1.InvokeMethodRequest) (*invokev1.InvokeMethodResponse, error) {
+	// Internal Dapr headers and context values are never delivered to the app
+	req.StripInternalHeaders()
 	channelReq := h.constructRequest(ctx, req)
 
 	if h.ch != nil {
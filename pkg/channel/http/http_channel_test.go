@@ -210,6 +210,31 @@ func TestInvokeWithHeaders(t *testing.T) {
 	testServer.Close()
 }
 
+func TestInvokeStripsInternalHeaders(t *testing.T) {
+	ctx := context.Background()
+	testServer := httptest.NewServer(&testHandlerHeaders{})
+	c := Channel{baseAddress: testServer.URL, client: &fasthttp.Client{}}
+
+	req := invokev1.NewInvokeMethodRequest("method")
+	req.WithMetadata(map[string][]string{"H1": {"v1"}})
+	req.WithContextValue("tenant", "acme")
+	req.WithHTTPExtension(http.MethodPost, "")
+
+	// act
+	response, err := c.InvokeMethod(ctx, req)
+
+	// assert
+	assert.NoError(t, err)
+	_, body := response.RawData()
+
+	actual := map[string]string{}
+	json.Unmarshal(body, &actual)
+
+	assert.Equal(t, "v1", actual["H1"])
+	assert.NotContains(t, actual, "Dapr-Ctx-Tenant")
+	testServer.Close()
+}
+
 func TestContentType(t *testing.T) {
 	ctx := context.Background()
 	t.Run("default application/json", func(t *testing.T) {
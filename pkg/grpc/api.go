@@ -236,7 +236,7 @@ func (a *api) InvokeService(ctx context.Context, in *runtimev1pb.InvokeServiceRe
 	req := invokev1.FromInvokeRequestMessage(in.GetMessage())
 
 	if incomingMD, ok := metadata.FromIncomingContext(ctx); ok {
-		req.WithMetadata(incomingMD)
+		req.WithMetadata(incomingMD).StripTrustHeaders()
 	}
 
 	resp, err := a.directMessaging.Invoke(ctx, in.Id, req)
@@ -581,10 +581,12 @@ func (a *api) onDirectMessage(reqCtx *fasthttp.RequestCtx) {
 	}
 
 	// Construct internal invoke method request
-	req := invokev1.NewInvokeMethodRequest(invokeMethodName).WithHTTPExtension(verb, reqCtx.QueryArgs().String())
+	req := invokev1.NewInvokeMethodRequest(invokeMethodName)
 	req.WithRawData(reqCtx.Request.Body(), string(reqCtx.Request.Header.ContentType()))
 	// Save headers to internal metadata
 	req.WithFastHTTPHeaders(&reqCtx.Request.Header)
+	// Set after the headers as it records the duplicate query keys in the metadata
+	req.WithHTTPExtension(verb, reqCtx.QueryArgs().String())
 
 	resp, err := a.directMessaging.Invoke(reqCtx, targetID, req)
 	// err does not represent user application response
@@ -828,7 +830,6 @@ func (a *api) onDirectActorMessage(reqCtx *fasthttp.RequestCtx) {
 
 	req := invokev1.NewInvokeMethodRequest(method)
 	req.WithActor(actorType, actorID)
-	req.WithHTTPExtension(verb, reqCtx.QueryArgs().String())
 	req.WithRawData(body, string(reqCtx.Request.Header.ContentType()))
 
 	// Save headers to metadata
@@ -836,7 +837,9 @@ func (a *api) onDirectActorMessage(reqCtx *fasthttp.RequestCtx) {
 	reqCtx.Request.Header.VisitAll(func(key []byte, value []byte) {
 		metadata[string(key)] = []string{string(value)}
 	})
-	req.WithMetadata(metadata)
+	req.WithMetadata(metadata).StripTrustHeaders()
+	// Set after the headers as it records the duplicate query keys in the metadata
+	req.WithHTTPExtension(verb, reqCtx.QueryArgs().String())
 
 	resp, err := a.actor.Call(reqCtx, req)
 	if err != nil {
@@ -6,21 +6,414 @@
 package v1
 
 import (
-	"errors"
+	"bytes"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math"
+	"mime"
+	"net"
+	"net/http"
 	"net/url"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
+	"unicode/utf8"
 
+	diag "github.com/dapr/dapr/pkg/diagnostics"
 	commonv1pb "github.com/dapr/dapr/pkg/proto/common/v1"
 	internalv1pb "github.com/dapr/dapr/pkg/proto/internals/v1"
+	"github.com/golang/protobuf/proto"
 	"github.com/golang/protobuf/ptypes/any"
+	"github.com/google/uuid"
+	"github.com/opentracing/opentracing-go"
+	"github.com/pkg/errors"
 	"github.com/valyala/fasthttp"
+	"github.com/vmihailenco/msgpack/v4"
+	"go.opencensus.io/trace"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/ianaindex"
+	"golang.org/x/text/language"
+	"google.golang.org/grpc/codes"
 )
 
 const (
 	// DefaultAPIVersion is the default Dapr API version
 	DefaultAPIVersion = internalv1pb.APIVersion_V1
+
+	// retryBudgetHeader is the reserved header carrying the retry budget tokens
+	retryBudgetHeader = DaprHeaderPrefix + "retry-budget"
+
+	// contentLengthHeader is the header key of content-length
+	contentLengthHeader = "content-length"
+
+	// authorizationHeader is the header key of authorization
+	authorizationHeader = "authorization"
+
+	// forwardedHeader is the header key of forwarded (RFC 7239)
+	forwardedHeader = "forwarded"
+
+	// errorHintCodeHeader and errorHintReasonHeader are the reserved headers carrying the error hint
+	errorHintCodeHeader   = DaprHeaderPrefix + "error-hint-code"
+	errorHintReasonHeader = DaprHeaderPrefix + "error-hint-reason"
+
+	// truncatedValueMarker is appended to the truncated metadata values
+	truncatedValueMarker = "..."
+
+	// timeZoneHeader is the reserved header carrying the IANA time zone of the caller
+	timeZoneHeader = DaprHeaderPrefix + "timezone"
+
+	// originHeader is the reserved header carrying the request origin
+	originHeader = DaprHeaderPrefix + "origin"
+
+	// cacheControlHeader is the header key of cache-control
+	cacheControlHeader = "cache-control"
+
+	// syntheticHeader is the reserved header marking synthetic monitoring traffic
+	syntheticHeader = DaprHeaderPrefix + "synthetic"
+
+	// contentMD5Header is the header key of content-md5
+	contentMD5Header = "content-md5"
+
+	// responseContentTypeHeader is the reserved header carrying the preferred response content type
+	responseContentTypeHeader = DaprHeaderPrefix + "response-content-type"
+
+	// fallbackMethodHeader is the reserved header carrying the fallback method name
+	fallbackMethodHeader = DaprHeaderPrefix + "fallback-method"
+
+	// traceLinksHeader is the reserved header carrying the span links of fan-in operations
+	traceLinksHeader = DaprHeaderPrefix + "trace-links"
+
+	// clientSendTimeHeader is the reserved header carrying the time the client sent the request
+	clientSendTimeHeader = DaprHeaderPrefix + "client-send-time"
+
+	// dpopHeader is the header key of the DPoP proof (RFC 9449)
+	dpopHeader = "dpop"
+
+	// shardKeyHeader is the reserved header carrying the shard key for stateful routing
+	shardKeyHeader = DaprHeaderPrefix + "shard-key"
+
+	// sagaIDHeader and sagaCompensationHeader are the reserved headers for saga orchestration
+	sagaIDHeader           = DaprHeaderPrefix + "saga-id"
+	sagaCompensationHeader = DaprHeaderPrefix + "saga-compensation"
+
+	// contentTransferEncodingHeader is the header key of content-transfer-encoding
+	contentTransferEncodingHeader = "content-transfer-encoding"
+	// originalContentTypeHeader is the reserved header preserving content_type of wrapped bodies
+	originalContentTypeHeader = DaprHeaderPrefix + "original-content-type"
+
+	// featureFlagsHeader is the reserved header carrying the feature flags of the request
+	featureFlagsHeader = DaprHeaderPrefix + "feature-flags"
+
+	// idempotencyKeyHeader is the header key of idempotency-key
+	idempotencyKeyHeader = "idempotency-key"
+
+	// transferEncodingHeader is the header key of transfer-encoding
+	transferEncodingHeader = "transfer-encoding"
+
+	// priorityHeader is the reserved header carrying the numeric priority of the request
+	priorityHeader = DaprHeaderPrefix + "priority"
+
+	// meshIdentityHeader is the reserved header carrying the verified identity of the calling workload
+	meshIdentityHeader = DaprHeaderPrefix + "mesh-identity"
+
+	// statusHintHeader is the reserved header carrying the expected gRPC status code
+	statusHintHeader = DaprHeaderPrefix + "status-hint"
+
+	// hopCountHeader is the reserved header carrying the number of mesh hops of the request
+	hopCountHeader = DaprHeaderPrefix + "hop-count"
+
+	// logLevelHeader is the reserved header carrying the per-request log level override
+	logLevelHeader = DaprHeaderPrefix + "log-level"
+
+	// deploymentColorHeader is the reserved header carrying the blue/green deployment selector
+	deploymentColorHeader = DaprHeaderPrefix + "deployment-color"
+
+	// acceptHeader is the header key of accept
+	acceptHeader = "accept"
+
+	// lockTokenHeader is the reserved header carrying the distributed lock token
+	lockTokenHeader = DaprHeaderPrefix + "lock-token"
+
+	// redactedValue replaces the values of sensitive headers
+	redactedValue = "<redacted>"
+
+	// consistencyHeader is the reserved header carrying the read consistency level
+	consistencyHeader = DaprHeaderPrefix + "consistency"
+
+	// resumeHeader is the reserved header advertising resumable upload support
+	resumeHeader = DaprHeaderPrefix + "resume"
+
+	// bypassCircuitBreakerHeader is the reserved header skipping the circuit breaker checks
+	bypassCircuitBreakerHeader = DaprHeaderPrefix + "bypass-circuit-breaker"
+
+	// defaultFairQueueTenant is the tenant of the fair queue key of requests without tenant
+	defaultFairQueueTenant = "_default"
+
+	// responseSchemaHeader is the reserved header carrying the requested response schema
+	responseSchemaHeader = DaprHeaderPrefix + "response-schema"
+
+	// dataRegionHeader is the reserved header carrying the data residency region constraint
+	dataRegionHeader = DaprHeaderPrefix + "data-region"
+	// duplicateQueryKeysHeader is the reserved header listing the querystring keys given more than once
+	duplicateQueryKeysHeader = DaprHeaderPrefix + "duplicate-query-keys"
+
+	// contextValuePrefix is the prefix of the internal metadata keys of the request context values
+	contextValuePrefix = DaprHeaderPrefix + "ctx-"
+
+	// keepAliveHeader is the header with the connection keep-alive parameters
+	keepAliveHeader = "keep-alive"
+
+	// samplingRatioHeader is the header with the trace sampling ratio override
+	samplingRatioHeader = DaprHeaderPrefix + "sampling-ratio"
+
+	// spanAttributesHeader is the header with the span attributes for the downstream sidecars
+	spanAttributesHeader = DaprHeaderPrefix + "span-attributes"
+
+	// targetWeightsHeader is the header with the client-side load balancing target weights
+	targetWeightsHeader = DaprHeaderPrefix + "target-weights"
+
+	// reentrancyIDHeader is the header with the id of the reentrant actor call chain
+	reentrancyIDHeader = DaprHeaderPrefix + "reentrancy-id"
+
+	// payloadVersionHeader is the header with the version of the payload schema
+	payloadVersionHeader = DaprHeaderPrefix + "payload-version"
+
+	// deprecationHeader and sunsetHeader signal the deprecation of an API (RFC 8594)
+	deprecationHeader = "deprecation"
+	sunsetHeader      = "sunset"
+
+	// pubsubNameHeader and topicHeader are the headers with the pub/sub component and
+	// topic of a message delivered to the app
+	pubsubNameHeader = DaprHeaderPrefix + "pubsub-name"
+	topicHeader      = DaprHeaderPrefix + "topic"
+
+	// bindingNameHeader and bindingMetadataHeader are the headers with the input binding
+	// and the metadata of its event
+	bindingNameHeader     = DaprHeaderPrefix + "binding-name"
+	bindingMetadataHeader = DaprHeaderPrefix + "binding-metadata"
+
+	// bindingTypeHeader is the header with the type of the input binding of the request
+	bindingTypeHeader = DaprHeaderPrefix + "binding-type"
+
+	// deadLetterTopicHeader is the header with the topic of the failed deliveries
+	deadLetterTopicHeader = DaprHeaderPrefix + "dead-letter-topic"
+
+	// expiryHeader is the header with the RFC3339 expiry time of the message
+	expiryHeader = DaprHeaderPrefix + "expiry"
+
+	// ackTokensHeader is the header with the tokens of a batch of acknowledged messages
+	ackTokensHeader = DaprHeaderPrefix + "ack-tokens"
+
+	// sequenceHeader is the header with the sequence number of the message in an ordered stream
+	sequenceHeader = DaprHeaderPrefix + "sequence"
+
+	// maxConcurrencyHeader is the header with the parallelism bound of the logical operation
+	maxConcurrencyHeader = DaprHeaderPrefix + "max-concurrency"
+
+	// warningHeader is the header with the warnings of the intermediaries (RFC 7234)
+	warningHeader = "warning"
+
+	// clientHintPrefix is the prefix of the client hint headers
+	clientHintPrefix = "sec-ch-"
+
+	// caseSensitiveRoutingHeader is the header which makes the router match the method case-sensitively
+	caseSensitiveRoutingHeader = DaprHeaderPrefix + "case-sensitive-routing"
+
+	// cacheHintHeader is the header with the cache-control directives the channel sets on the response
+	cacheHintHeader = DaprHeaderPrefix + "cache-hint"
+
+	// minTLSVersionHeader is the header with the minimum TLS version of the connection
+	minTLSVersionHeader = DaprHeaderPrefix + "min-tls-version"
+
+	// acceptCharsetHeader is the header with the charsets accepted by the caller
+	acceptCharsetHeader = "accept-charset"
+
+	// retryPolicyHeader is the header with the JSON retry policy override of the request
+	retryPolicyHeader = DaprHeaderPrefix + "retry-policy"
+
+	// breakGlassHeader is the header with the reason of an emergency access bypassing the mesh policies
+	breakGlassHeader = DaprHeaderPrefix + "break-glass"
+
+	// samplingKeyHeader is the header with the key correlating the spans for tail-based sampling
+	samplingKeyHeader = DaprHeaderPrefix + "sampling-key"
+
+	// ifMatchHeader is the header with the entity tags of a conditional request
+	ifMatchHeader = "if-match"
+
+	// costUnitsHeader is the header with the quota units the request costs
+	costUnitsHeader = DaprHeaderPrefix + "cost-units"
+
+	// streamTypeHeader is the header with the streaming type of the target gRPC method
+	streamTypeHeader = DaprHeaderPrefix + "stream-type"
+
+	// actorPartitionHeader is the header with the placement partition hint of the actor
+	actorPartitionHeader = DaprHeaderPrefix + "actor-partition"
+
+	// varyHeader is the header listing the request headers which select the response
+	varyHeader = "vary"
+
+	// experimentsHeader is the header with the A/B test variants assigned to the request
+	experimentsHeader = DaprHeaderPrefix + "experiments"
+
+	// maxTraceStateEntries is the maximum number of list members of tracestate
+	maxTraceStateEntries = 32
+
+	// healthCheckTimeout is the deadline of the requests created by NewHealthCheckRequest
+	healthCheckTimeout = 5 * time.Second
+
+	// clientSDKHeader is the header with the name/version of the client SDK of the caller
+	clientSDKHeader = DaprHeaderPrefix + "client-sdk"
+
+	// tracingDisabledHeader is the header which makes the observability layer skip the spans of the request
+	tracingDisabledHeader = DaprHeaderPrefix + "tracing-disabled"
+
+	// sloHeader is the header with the service level objective the request is measured against
+	sloHeader = DaprHeaderPrefix + "slo"
+
+	// compensationPayloadHeader and compensationContentTypeHeader are the headers with the
+	// base64 encoded rollback payload of the saga step and its content type
+	compensationPayloadHeader     = DaprHeaderPrefix + "saga-compensation-payload"
+	compensationContentTypeHeader = DaprHeaderPrefix + "saga-compensation-content-type"
+
+	// proxyAuthorizationHeader is the header with the credentials of the caller for the proxy
+	proxyAuthorizationHeader = "proxy-authorization"
+
+	// dataClassificationHeader is the header with the data governance classification of the request
+	dataClassificationHeader = DaprHeaderPrefix + "data-classification"
+)
+
+// RequestOrigin is the origin of the invocation request
+type RequestOrigin string
+
+const (
+	// OriginExternal is the origin of requests coming from outside of the mesh
+	OriginExternal RequestOrigin = "external"
+	// OriginInternal is the origin of requests coming from another Dapr sidecar
+	OriginInternal RequestOrigin = "internal"
 )
 
+// priorityClasses maps the named priority classes to numeric priorities
+var priorityClasses = map[string]int{
+	"low":      0,
+	"normal":   1,
+	"high":     2,
+	"critical": 3,
+}
+
+// dataClassifications are the known data classification labels
+var dataClassifications = map[string]bool{
+	"public":       true,
+	"internal":     true,
+	"confidential": true,
+	"restricted":   true,
+}
+
+// defaultPriorityClass is the class of requests with unknown or no priority
+const defaultPriorityClass = "normal"
+
+// trustHeaders are the headers which only the sidecar may set as the trust decisions are
+// based on them. StripTrustHeaders removes them from the requests of external callers.
+var trustHeaders = []string{originHeader, meshIdentityHeader, breakGlassHeader, duplicateQueryKeysHeader}
+
+// internalHeaders are the Dapr routing headers which are only meaningful between sidecars
+// and are removed by StripInternalHeaders before the request is delivered to the app.
+// Trace context headers are not part of this list.
+var internalHeaders = []string{
+	DestinationIDHeader,
+	DaprHeaderPrefix + "api-token",
+	originHeader,
+	meshIdentityHeader,
+	hopCountHeader,
+	shardKeyHeader,
+	priorityHeader,
+	retryBudgetHeader,
+	fallbackMethodHeader,
+	deploymentColorHeader,
+	bypassCircuitBreakerHeader,
+	dataRegionHeader,
+	duplicateQueryKeysHeader,
+}
+
+// topologyHeaders are the forwarding headers which leak the internal topology and are
+// removed by StripTopologyHeaders, along with the x-forwarded-* headers
+var topologyHeaders = []string{"via", forwardedHeader, "server"}
+
+// vendorMediaTypeRegex matches versioned vendor media types like application/vnd.acme.v3+json
+var vendorMediaTypeRegex = regexp.MustCompile(`^application/vnd\.([a-z0-9][a-z0-9.-]*)\.v([0-9]+(?:\.[0-9]+)*)\+([a-z0-9.-]+)$`)
+
+// traceStateKeyRegex matches the W3C tracestate keys, either a simple key or a
+// tenant@system multi-tenant key, of at most 256 characters
+var traceStateKeyRegex = regexp.MustCompile(`^(?:[a-z][a-z0-9_\-*/]{0,255}|[a-z0-9][a-z0-9_\-*/]{0,240}@[a-z][a-z0-9_\-*/]{0,13})$`)
+
+// sensitiveHeaders are the headers carrying credentials which must never be logged
+var sensitiveHeaders = map[string]bool{
+	authorizationHeader:            true,
+	proxyAuthorizationHeader:       true,
+	"cookie":                       true,
+	"set-cookie":                   true,
+	DaprHeaderPrefix + "api-token": true,
+	"x-api-key":                    true,
+}
+
+// coalescingHeaders are the headers which change the response of GET requests
+// and must match for two requests to be coalesced
+var coalescingHeaders = []string{"accept", "accept-encoding", "accept-language", authorizationHeader, "cookie"}
+
+// TransactionOp is a state operation of an actor state transaction. It mirrors the
+// operations accepted by the actors/{actorType}/{actorId}/state API.
+type TransactionOp struct {
+	// Operation is either upsert or delete
+	Operation string `json:"operation"`
+	// Request is the key, and the value for upserts, of the operation
+	Request interface{} `json:"request"`
+}
+
+// ForwardedElement is a single forwarded-element of the Forwarded header (RFC 7239)
+type ForwardedElement struct {
+	For   string
+	By    string
+	Host  string
+	Proto string
+}
+
+// WarningHeader is a single warning-value of the Warning header (RFC 7234)
+type WarningHeader struct {
+	Code  int
+	Agent string
+	Text  string
+	// Date is zero if the warning has no date
+	Date time.Time
+}
+
+// RetryPolicy is the per-request override of the retry policy
+type RetryPolicy struct {
+	MaxRetries int           `json:"maxRetries"`
+	Backoff    time.Duration `json:"backoff"`
+}
+
+// WorkItem is a serializable descriptor of a request queued for asynchronous processing
+type WorkItem struct {
+	Method      string              `json:"method"`
+	Verb        string              `json:"verb,omitempty"`
+	Querystring string              `json:"querystring,omitempty"`
+	ContentType string              `json:"contentType,omitempty"`
+	Data        []byte              `json:"data,omitempty"`
+	Metadata    map[string][]string `json:"metadata,omitempty"`
+	ActorType   string              `json:"actorType,omitempty"`
+	ActorID     string              `json:"actorId,omitempty"`
+	EnqueueTime time.Time           `json:"enqueueTime"`
+}
+
 // InvokeMethodRequest holds InternalInvokeRequest protobuf message
 // and provides the helpers to manage it.
 type InvokeMethodRequest struct {
@@ -49,6 +442,69 @@ func FromInvokeRequestMessage(pb *commonv1pb.InvokeRequest) *InvokeMethodRequest
 	}
 }
 
+// NewActorStateTransactionRequest creates InvokeMethodRequest object for the state
+// transaction ops of the actor
+func NewActorStateTransactionRequest(actorType, actorID string, ops []TransactionOp) (*InvokeMethodRequest, error) {
+	if len(ops) == 0 {
+		return nil, errors.New("actor state transaction has no operations")
+	}
+	data, err := json.Marshal(ops)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to encode actor state transaction")
+	}
+
+	req := NewInvokeMethodRequest(fmt.Sprintf("actors/%s/%s/state", actorType, actorID))
+	req.WithActor(actorType, actorID)
+	req.WithHTTPExtension(commonv1pb.HTTPExtension_POST.String(), "")
+	req.WithRawData(data, JSONContentType)
+	return req, nil
+}
+
+// NewTopicDeliveryRequest creates InvokeMethodRequest object for the delivery of a
+// pub/sub message to the subscription route of the app. The route defaults to the topic
+// name and is set with WithRoute when the subscription route differs from it
+func NewTopicDeliveryRequest(pubsubName, topic string, data []byte, contentType string) *InvokeMethodRequest {
+	req := NewInvokeMethodRequest(topic)
+	req.WithHTTPExtension(commonv1pb.HTTPExtension_POST.String(), "")
+	req.setMetadataValue(pubsubNameHeader, pubsubName)
+	req.setMetadataValue(topicHeader, topic)
+	req.WithRawData(data, contentType)
+	return req
+}
+
+// FromWorkItem creates InvokeMethodRequest object from the WorkItem created by ToWorkItem
+func FromWorkItem(item *WorkItem) (*InvokeMethodRequest, error) {
+	if item == nil || item.Method == "" {
+		return nil, errors.New("work item has no method")
+	}
+
+	req := NewInvokeMethodRequest(item.Method)
+	if item.Verb != "" || item.Querystring != "" {
+		req.WithHTTPExtension(item.Verb, item.Querystring)
+	}
+	if item.Data != nil {
+		req.WithRawData(item.Data, item.ContentType)
+	}
+	if len(item.Metadata) > 0 {
+		req.WithMetadata(item.Metadata)
+	}
+	if item.ActorType != "" {
+		req.WithActor(item.ActorType, item.ActorID)
+	}
+	return req, nil
+}
+
+// NewHealthCheckRequest creates InvokeMethodRequest object for a GET to the healthz
+// endpoint of appID. The request is marked as synthetic and expires after healthCheckTimeout.
+func NewHealthCheckRequest(appID string) *InvokeMethodRequest {
+	req := NewInvokeMethodRequest("healthz")
+	req.WithHTTPExtension(commonv1pb.HTTPExtension_GET.String(), "")
+	req.setMetadataValue(DestinationIDHeader, appID)
+	req.WithSynthetic("health-check")
+	req.WithTTL(healthCheckTimeout)
+	return req
+}
+
 // InternalInvokeRequest creates InvokeMethodRequest object from InternalInvokeRequest pb object
 func InternalInvokeRequest(pb *internalv1pb.InternalInvokeRequest) (*InvokeMethodRequest, error) {
 	req := &InvokeMethodRequest{r: pb}
@@ -71,13 +527,24 @@ func (imr *InvokeMethodRequest) WithMetadata(md map[string][]string) *InvokeMeth
 	return imr
 }
 
-// WithFastHTTPHeaders sets fasthttp request headers
+// WithFastHTTPHeaders sets fasthttp request headers. The headers come from an external
+// caller, so the trust headers are removed.
 func (imr *InvokeMethodRequest) WithFastHTTPHeaders(header *fasthttp.RequestHeader) *InvokeMethodRequest {
 	md := map[string][]string{}
 	header.VisitAll(func(key []byte, value []byte) {
-		md[string(key)] = []string{string(value)}
+		md[string(key)] = append(md[string(key)], string(value))
 	})
 	imr.r.Metadata = MetadataToInternalMetadata(md)
+	return imr.StripTrustHeaders()
+}
+
+// StripTrustHeaders removes the headers on which the trust decisions are based, such as
+// the origin of the request. It must be called on the metadata of external callers so
+// that they cannot forge them.
+func (imr *InvokeMethodRequest) StripTrustHeaders() *InvokeMethodRequest {
+	for _, hdr := range trustHeaders {
+		imr.deleteMetadata(hdr)
+	}
 	return imr
 }
 
@@ -91,7 +558,9 @@ func (imr *InvokeMethodRequest) WithRawData(data []byte, contentType string) *In
 	return imr
 }
 
-// WithHTTPExtension sets new HTTP extension with verb and querystring
+// WithHTTPExtension sets new HTTP extension with verb and querystring. The querystring of
+// HTTPExtension keeps only the first value of each key, so the keys given more than once
+// are recorded in the duplicate query keys header.
 func (imr *InvokeMethodRequest) WithHTTPExtension(verb string, querystring string) *InvokeMethodRequest {
 	httpMethod, ok := commonv1pb.HTTPExtension_Verb_value[strings.ToUpper(verb)]
 	if !ok {
@@ -99,12 +568,24 @@ func (imr *InvokeMethodRequest) WithHTTPExtension(verb string, querystring strin
 	}
 
 	var metadata = map[string]string{}
+	var duplicates []string
 	if querystring != "" {
 		params, _ := url.ParseQuery(querystring)
 
 		for k, v := range params {
 			metadata[k] = v[0]
+			if len(v) > 1 {
+				duplicates = append(duplicates, k)
+			}
+		}
+	}
+	imr.deleteMetadata(duplicateQueryKeysHeader)
+	if len(duplicates) > 0 {
+		sort.Strings(duplicates)
+		if imr.r.Metadata == nil {
+			imr.r.Metadata = DaprInternalMetadata{}
 		}
+		imr.r.Metadata[duplicateQueryKeysHeader] = &internalv1pb.ListStringValue{Values: duplicates}
 	}
 
 	imr.r.Message.HttpExtension = &commonv1pb.HTTPExtension{
@@ -177,3 +658,2947 @@ func (imr *InvokeMethodRequest) RawData() (string, []byte) {
 
 	return contentType, dataValue
 }
+
+// ForceSampled sets the sampled flag of the traceparent header, if present, so that
+// the downstream spans are always recorded.
+func (imr *InvokeMethodRequest) ForceSampled() *InvokeMethodRequest {
+	traceparent, ok := imr.metadataValue(traceparentHeader)
+	if !ok {
+		return imr
+	}
+
+	sc, ok := diag.SpanContextFromW3CString(traceparent)
+	if !ok {
+		return imr
+	}
+	sc.TraceOptions |= trace.TraceOptions(1)
+	imr.setMetadataValue(traceparentHeader, diag.SpanContextToW3CString(sc))
+	return imr
+}
+
+// ActorKey returns the actor type and id joined as type/id, and false if
+// either of them is unset
+func (imr *InvokeMethodRequest) ActorKey() (string, bool) {
+	actor := imr.r.GetActor()
+	if actor.GetActorType() == "" || actor.GetActorId() == "" {
+		return "", false
+	}
+	return actor.GetActorType() + "/" + actor.GetActorId(), true
+}
+
+// EchoResponse returns what the sidecar received for this request, the method, the verb
+// and the headers as echoed by HeaderEcho, serialized as JSON body for the diagnostic echo
+// endpoint. Sensitive headers are left out so that the endpoint never reflects credentials.
+func (imr *InvokeMethodRequest) EchoResponse() (contentType string, body []byte) {
+	headers := imr.echoHeaders()
+
+	echo := struct {
+		Method  string              `json:"method"`
+		Verb    string              `json:"verb,omitempty"`
+		Headers map[string][]string `json:"headers"`
+	}{
+		Method:  imr.r.GetMessage().GetMethod(),
+		Headers: headers,
+	}
+	if ext := imr.r.GetMessage().GetHttpExtension(); ext != nil {
+		echo.Verb = ext.GetVerb().String()
+	}
+
+	// marshaling plain strings and string maps cannot fail
+	body, _ = json.Marshal(echo)
+	return JSONContentType, body
+}
+
+// WithRetryBudget sets the number of retry budget tokens for adaptive retries
+func (imr *InvokeMethodRequest) WithRetryBudget(tokens float64) *InvokeMethodRequest {
+	imr.setMetadataValue(retryBudgetHeader, strconv.FormatFloat(tokens, 'f', -1, 64))
+	return imr
+}
+
+// RetryBudget returns the retry budget tokens, and false if it is unset or malformed
+func (imr *InvokeMethodRequest) RetryBudget() (float64, bool) {
+	val, ok := imr.metadataValue(retryBudgetHeader)
+	if !ok {
+		return 0, false
+	}
+	tokens, err := strconv.ParseFloat(val, 64)
+	if err != nil {
+		return 0, false
+	}
+	return tokens, true
+}
+
+// NormalizeHead clears the body of HEAD requests and sets content-length to 0
+// because neither the request nor the response of HEAD carries a body
+func (imr *InvokeMethodRequest) NormalizeHead() *InvokeMethodRequest {
+	if imr.r.GetMessage().GetHttpExtension().GetVerb() != commonv1pb.HTTPExtension_HEAD {
+		return imr
+	}
+	imr.r.Message.Data = nil
+	imr.setMetadataValue(contentLengthHeader, "0")
+	return imr
+}
+
+// AuthorizationValues returns all the authorization header values
+func (imr *InvokeMethodRequest) AuthorizationValues() []string {
+	return imr.metadataValues(authorizationHeader)
+}
+
+// ContentTypeRaw returns content_type verbatim regardless of whether the body is set
+func (imr *InvokeMethodRequest) ContentTypeRaw() string {
+	return imr.r.GetMessage().GetContentType()
+}
+
+// Forwarded parses the forwarded header into the list of forwarded elements
+func (imr *InvokeMethodRequest) Forwarded() []ForwardedElement {
+	var elements []ForwardedElement
+	for _, val := range imr.metadataValues(forwardedHeader) {
+		for _, elem := range splitOutsideQuotes(val, ',') {
+			var el ForwardedElement
+			for _, pair := range splitOutsideQuotes(elem, ';') {
+				kv := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+				if len(kv) != 2 {
+					continue
+				}
+				v := kv[1]
+				if strings.HasPrefix(v, "\"") {
+					var ok bool
+					if v, _, ok = parseQuotedString(v, 0); !ok {
+						continue
+					}
+				}
+				switch strings.ToLower(kv[0]) {
+				case "for":
+					el.For = v
+				case "by":
+					el.By = v
+				case "host":
+					el.Host = v
+				case "proto":
+					el.Proto = v
+				}
+			}
+			if el != (ForwardedElement{}) {
+				elements = append(elements, el)
+			}
+		}
+	}
+	return elements
+}
+
+// AppendForwarded appends the forwarded element to the forwarded header
+func (imr *InvokeMethodRequest) AppendForwarded(el ForwardedElement) *InvokeMethodRequest {
+	var pairs []string
+	for _, p := range []struct{ k, v string }{{"for", el.For}, {"by", el.By}, {"host", el.Host}, {"proto", el.Proto}} {
+		if p.v == "" {
+			continue
+		}
+		v := p.v
+		// values such as IPv6 addresses or host:port must be quoted
+		if strings.ContainsAny(v, ":[]\";,= ") {
+			v = strconv.Quote(v)
+		}
+		pairs = append(pairs, p.k+"="+v)
+	}
+	if len(pairs) == 0 {
+		return imr
+	}
+
+	forwarded := strings.Join(pairs, ";")
+	if existing, ok := imr.metadataValue(forwardedHeader); ok && existing != "" {
+		forwarded = existing + ", " + forwarded
+	}
+	imr.setMetadataValue(forwardedHeader, forwarded)
+	return imr
+}
+
+// Coalescable returns true if both requests are GETs with the same method, querystring,
+// target actor and response-affecting headers, so that a single invocation can serve both
+func (imr *InvokeMethodRequest) Coalescable(other *InvokeMethodRequest) bool {
+	if other == nil {
+		return false
+	}
+
+	a, oa := imr.r.GetActor(), other.r.GetActor()
+	if (a == nil) != (oa == nil) || a.GetActorType() != oa.GetActorType() || a.GetActorId() != oa.GetActorId() {
+		return false
+	}
+
+	m, o := imr.r.GetMessage(), other.r.GetMessage()
+	if m.GetHttpExtension().GetVerb() != commonv1pb.HTTPExtension_GET ||
+		o.GetHttpExtension().GetVerb() != commonv1pb.HTTPExtension_GET {
+		return false
+	}
+	if m.GetMethod() != o.GetMethod() || imr.EncodeHTTPQueryString() != other.EncodeHTTPQueryString() {
+		return false
+	}
+
+	for _, hdr := range coalescingHeaders {
+		if strings.Join(imr.metadataValues(hdr), ",") != strings.Join(other.metadataValues(hdr), ",") {
+			return false
+		}
+	}
+	return true
+}
+
+// WithErrorHint sets the error code and reason of a replayed failure for the target
+func (imr *InvokeMethodRequest) WithErrorHint(code, reason string) *InvokeMethodRequest {
+	imr.setMetadataValue(errorHintCodeHeader, code)
+	imr.setMetadataValue(errorHintReasonHeader, reason)
+	return imr
+}
+
+// ErrorHint returns the error code and reason, and false if no error hint is set
+func (imr *InvokeMethodRequest) ErrorHint() (code, reason string, ok bool) {
+	code, ok = imr.metadataValue(errorHintCodeHeader)
+	if !ok {
+		return "", "", false
+	}
+	reason, _ = imr.metadataValue(errorHintReasonHeader)
+	return code, reason, true
+}
+
+// IsGRPCWeb returns true if the content type is grpc-web or grpc-web-text
+func (imr *InvokeMethodRequest) IsGRPCWeb() bool {
+	contentType := strings.ToLower(imr.r.GetMessage().GetContentType())
+	if contentType == "" {
+		contentType, _ = imr.metadataValue(ContentTypeHeader)
+		contentType = strings.ToLower(contentType)
+	}
+	return strings.HasPrefix(contentType, GRPCWebContentType)
+}
+
+// UnframeGRPCWebText decodes the base64 encoded grpc-web-text body into the binary
+// grpc-web frames and updates content_type accordingly
+func (imr *InvokeMethodRequest) UnframeGRPCWebText() error {
+	m := imr.r.Message
+	contentType := strings.ToLower(m.GetContentType())
+	if !strings.HasPrefix(contentType, GRPCWebTextContentType) {
+		return nil
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(string(m.GetData().GetValue()))
+	if err != nil {
+		return errors.Wrap(err, "failed to decode grpc-web-text body")
+	}
+	m.ContentType = GRPCWebContentType + strings.TrimPrefix(contentType, GRPCWebTextContentType)
+	m.Data = &any.Any{Value: decoded}
+	return nil
+}
+
+// QueryParamCount returns the number of distinct querystring keys
+func (imr *InvokeMethodRequest) QueryParamCount() int {
+	return len(imr.r.GetMessage().GetHttpExtension().GetQuerystring())
+}
+
+// TruncateMetadataValues truncates the metadata values longer than max bytes so that
+// they fit in max bytes including the trailing ellipsis marker. Binary metadata is
+// skipped because truncated base64 values cannot be decoded. A negative max is treated as 0.
+func (imr *InvokeMethodRequest) TruncateMetadataValues(max int) *InvokeMethodRequest {
+	if max < 0 {
+		max = 0
+	}
+	for k, listVal := range imr.r.GetMetadata() {
+		if strings.HasSuffix(k, gRPCBinaryMetadataSuffix) {
+			continue
+		}
+		for i, val := range listVal.Values {
+			if len(val) <= max {
+				continue
+			}
+			cut := max - len(truncatedValueMarker)
+			if cut < 0 {
+				cut = 0
+			}
+			// avoid cutting a multi-byte character in half
+			for cut > 0 && !utf8.RuneStart(val[cut]) {
+				cut--
+			}
+			listVal.Values[i] = val[:cut] + truncatedValueMarker
+			if len(listVal.Values[i]) > max {
+				listVal.Values[i] = listVal.Values[i][:max]
+			}
+		}
+	}
+	return imr
+}
+
+// GetMetadataInt parses the first value of the metadata key as int, and returns def
+// if the key is absent or the value is not an integer
+func (imr *InvokeMethodRequest) GetMetadataInt(key string, def int) int {
+	val, ok := imr.metadataValue(key)
+	if !ok {
+		return def
+	}
+	i, err := strconv.Atoi(strings.TrimSpace(val))
+	if err != nil {
+		return def
+	}
+	return i
+}
+
+// WithTimeZone sets the IANA time zone name of the caller, e.g. Europe/Paris
+func (imr *InvokeMethodRequest) WithTimeZone(tz string) *InvokeMethodRequest {
+	imr.setMetadataValue(timeZoneHeader, tz)
+	return imr
+}
+
+// TimeZone returns the location of the caller time zone, and false if it is unset or invalid
+func (imr *InvokeMethodRequest) TimeZone() (*time.Location, bool) {
+	tz, ok := imr.metadataValue(timeZoneHeader)
+	if !ok || tz == "" {
+		return nil, false
+	}
+	loc, err := time.LoadLocation(tz)
+	if err != nil {
+		return nil, false
+	}
+	return loc, true
+}
+
+// EffectiveVerbFor returns the verb used to invoke the target app over protocol. gRPC
+// apps are always invoked with POST while HTTP apps get the verb of NormalizedVerb.
+func (imr *InvokeMethodRequest) EffectiveVerbFor(protocol string) string {
+	if strings.EqualFold(protocol, "grpc") {
+		return commonv1pb.HTTPExtension_POST.String()
+	}
+	return imr.NormalizedVerb()
+}
+
+// WithOrigin sets the origin of the request
+func (imr *InvokeMethodRequest) WithOrigin(origin RequestOrigin) *InvokeMethodRequest {
+	imr.setMetadataValue(originHeader, string(origin))
+	return imr
+}
+
+// Origin returns the origin of the request. Requests without a known origin are
+// considered external. The origin header is removed from the metadata of external
+// callers by StripTrustHeaders.
+func (imr *InvokeMethodRequest) Origin() RequestOrigin {
+	if val, _ := imr.metadataValue(originHeader); RequestOrigin(val) == OriginInternal {
+		return OriginInternal
+	}
+	return OriginExternal
+}
+
+// StripAuthForInternal removes the authorization header of the requests originated
+// inside of the mesh
+func (imr *InvokeMethodRequest) StripAuthForInternal() *InvokeMethodRequest {
+	if imr.Origin() == OriginInternal {
+		imr.deleteMetadata(authorizationHeader)
+	}
+	return imr
+}
+
+// CacheControl parses the cache-control header into directive and value pairs.
+// Directives without value are mapped to an empty string.
+func (imr *InvokeMethodRequest) CacheControl() map[string]string {
+	directives := map[string]string{}
+	for _, val := range imr.metadataValues(cacheControlHeader) {
+		for _, directive := range strings.Split(val, ",") {
+			kv := strings.SplitN(strings.TrimSpace(directive), "=", 2)
+			name := strings.ToLower(kv[0])
+			if name == "" {
+				continue
+			}
+			if len(kv) == 2 {
+				directives[name] = strings.Trim(kv[1], "\"")
+			} else {
+				directives[name] = ""
+			}
+		}
+	}
+	return directives
+}
+
+// NoTransform returns true if cache-control has the no-transform directive, which
+// forbids the channel to recompress or otherwise transform the body
+func (imr *InvokeMethodRequest) NoTransform() bool {
+	_, ok := imr.CacheControl()["no-transform"]
+	return ok
+}
+
+// WithSynthetic marks the request as synthetic traffic generated by source
+func (imr *InvokeMethodRequest) WithSynthetic(source string) *InvokeMethodRequest {
+	imr.setMetadataValue(syntheticHeader, source)
+	return imr
+}
+
+// IsSynthetic returns the source of synthetic traffic, and false if the request is not synthetic
+func (imr *InvokeMethodRequest) IsSynthetic() (string, bool) {
+	return imr.metadataValue(syntheticHeader)
+}
+
+// CanonicalRequest builds the canonical request representation used for AWS SigV4
+// style signing: the verb, the canonical path, the canonical querystring, the canonical
+// signed headers, the signed header list and the hex encoded SHA256 of the body,
+// separated by new lines.
+func (imr *InvokeMethodRequest) CanonicalRequest(signedHeaders []string) string {
+	m := imr.r.GetMessage()
+
+	segments := strings.Split(strings.TrimPrefix(m.GetMethod(), "/"), "/")
+	for i, seg := range segments {
+		segments[i] = sigV4Escape(seg)
+	}
+	canonicalPath := "/" + strings.Join(segments, "/")
+
+	// SigV4 sorts the parameters by escaped name and then by escaped value. Sorting the
+	// joined pairs would put a=1 after a-b=3 and a1=2 as '-' and digits sort before '='.
+	qs := m.GetHttpExtension().GetQuerystring()
+	pairs := make([][2]string, 0, len(qs))
+	for k, v := range qs {
+		pairs = append(pairs, [2]string{sigV4Escape(k), sigV4Escape(v)})
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		if pairs[i][0] != pairs[j][0] {
+			return pairs[i][0] < pairs[j][0]
+		}
+		return pairs[i][1] < pairs[j][1]
+	})
+	params := make([]string, len(pairs))
+	for i, pair := range pairs {
+		params[i] = pair[0] + "=" + pair[1]
+	}
+
+	names := make([]string, 0, len(signedHeaders))
+	for _, hdr := range signedHeaders {
+		names = append(names, strings.ToLower(hdr))
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		values := imr.metadataValues(name)
+		for i, val := range values {
+			values[i] = strings.Join(strings.Fields(val), " ")
+		}
+		canonicalHeaders.WriteString(name + ":" + strings.Join(values, ",") + "\n")
+	}
+
+	bodyHash := sha256.Sum256(m.GetData().GetValue())
+
+	return strings.Join([]string{
+		imr.EffectiveVerbFor("http"),
+		canonicalPath,
+		strings.Join(params, "&"),
+		canonicalHeaders.String(),
+		strings.Join(names, ";"),
+		hex.EncodeToString(bodyHash[:]),
+	}, "\n")
+}
+
+// BodyLooksCompressed returns true if the body starts with the gzip, zlib or zstd magic
+// number regardless of content-encoding, so that the body is not compressed twice
+func (imr *InvokeMethodRequest) BodyLooksCompressed() bool {
+	data := imr.r.GetMessage().GetData().GetValue()
+	switch {
+	case len(data) >= 4 && data[0] == 0x28 && data[1] == 0xb5 && data[2] == 0x2f && data[3] == 0xfd:
+		// zstd
+		return true
+	case len(data) >= 2 && data[0] == 0x1f && data[1] == 0x8b:
+		// gzip
+		return true
+	case len(data) >= 2 && data[0] == 0x78 && data[1]&0x20 == 0 && (uint16(data[0])<<8|uint16(data[1]))%31 == 0:
+		// zlib with deflate method, 32K window and no preset dictionary, header checksum must be valid
+		return true
+	}
+	return false
+}
+
+// WithQueryStruct encodes the exported fields of the struct v into the querystring of
+// the HTTP extension. Field names are taken from the url tag, e.g. `url:"name,omitempty"`,
+// and slices are encoded as comma separated values.
+func (imr *InvokeMethodRequest) WithQueryStruct(v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() == reflect.Ptr {
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		return errors.Errorf("querystring value must be a struct, got %s", rv.Kind())
+	}
+
+	params := map[string]string{}
+	rt := rv.Type()
+	for i := 0; i < rt.NumField(); i++ {
+		field := rt.Field(i)
+		if field.PkgPath != "" {
+			// unexported field
+			continue
+		}
+
+		name, opts := field.Name, ""
+		if tag, ok := field.Tag.Lookup("url"); ok {
+			if tag == "-" {
+				continue
+			}
+			parts := strings.SplitN(tag, ",", 2)
+			if parts[0] != "" {
+				name = parts[0]
+			}
+			if len(parts) == 2 {
+				opts = parts[1]
+			}
+		}
+
+		fv := rv.Field(i)
+		if opts == "omitempty" && fv.IsZero() {
+			continue
+		}
+
+		var val string
+		if fv.Kind() == reflect.Slice || fv.Kind() == reflect.Array {
+			items := make([]string, 0, fv.Len())
+			for j := 0; j < fv.Len(); j++ {
+				item, err := queryValueString(fv.Index(j))
+				if err != nil {
+					return errors.Wrapf(err, "invalid querystring field %s", field.Name)
+				}
+				items = append(items, item)
+			}
+			val = strings.Join(items, ",")
+		} else {
+			var err error
+			if val, err = queryValueString(fv); err != nil {
+				return errors.Wrapf(err, "invalid querystring field %s", field.Name)
+			}
+		}
+		params[name] = val
+	}
+
+	if imr.r.Message.HttpExtension == nil {
+		imr.WithHTTPExtension("", "")
+	}
+	if imr.r.Message.HttpExtension.Querystring == nil {
+		imr.r.Message.HttpExtension.Querystring = map[string]string{}
+	}
+	for k, val := range params {
+		imr.r.Message.HttpExtension.Querystring[k] = val
+	}
+
+	// the keys set from the struct have a single value
+	if duplicates := imr.metadataValues(duplicateQueryKeysHeader); len(duplicates) > 0 {
+		var kept []string
+		for _, k := range duplicates {
+			if _, ok := params[k]; !ok {
+				kept = append(kept, k)
+			}
+		}
+		imr.deleteMetadata(duplicateQueryKeysHeader)
+		if len(kept) > 0 {
+			imr.r.Metadata[duplicateQueryKeysHeader] = &internalv1pb.ListStringValue{Values: kept}
+		}
+	}
+	return nil
+}
+
+// VerifyContentMD5 compares the content-md5 header with the MD5 digest of the body.
+// It returns nil if the header is absent.
+func (imr *InvokeMethodRequest) VerifyContentMD5() error {
+	val, ok := imr.metadataValue(contentMD5Header)
+	if !ok {
+		return nil
+	}
+	expected, err := base64.StdEncoding.DecodeString(strings.TrimSpace(val))
+	if err != nil {
+		return errors.Wrap(err, "failed to decode content-md5 header")
+	}
+	digest := md5.Sum(imr.r.GetMessage().GetData().GetValue()) //nolint:gosec
+	if !bytes.Equal(expected, digest[:]) {
+		return errors.New("content-md5 does not match the body")
+	}
+	return nil
+}
+
+// CanaryBucket hashes the value of headerKey into a stable bucket in [0, buckets) for
+// percentage based rollouts. It returns false if the header is absent.
+func (imr *InvokeMethodRequest) CanaryBucket(headerKey string, buckets int) (int, bool) {
+	val, ok := imr.metadataValue(headerKey)
+	if !ok || buckets <= 0 {
+		return 0, false
+	}
+	return hashBucket(val, buckets), true
+}
+
+// ToMessagePack converts the JSON body to MessagePack and updates content_type
+func (imr *InvokeMethodRequest) ToMessagePack() error {
+	contentType, data := imr.RawData()
+	if strings.HasPrefix(strings.ToLower(contentType), MessagePackContentType) {
+		return nil
+	}
+	if !IsJSONContentType(contentType) {
+		return errors.Errorf("cannot convert %s body to MessagePack", contentType)
+	}
+
+	// decode numbers as json.Number so that integers are packed as integers
+	// rather than as float64, which loses precision above 2^53
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return errors.Wrap(err, "failed to decode JSON body")
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return errors.New("failed to decode JSON body: unexpected data after top-level value")
+	}
+	packed, err := msgpack.Marshal(convertJSONNumbers(v))
+	if err != nil {
+		return errors.Wrap(err, "failed to encode MessagePack body")
+	}
+	imr.WithRawData(packed, MessagePackContentType)
+	return nil
+}
+
+// ToJSON converts the MessagePack body to JSON and updates content_type
+func (imr *InvokeMethodRequest) ToJSON() error {
+	contentType, data := imr.RawData()
+	if IsJSONContentType(contentType) {
+		return nil
+	}
+	if !strings.HasPrefix(strings.ToLower(contentType), MessagePackContentType) {
+		return errors.Errorf("cannot convert %s body to JSON", contentType)
+	}
+
+	var v interface{}
+	if err := msgpack.Unmarshal(data, &v); err != nil {
+		return errors.Wrap(err, "failed to decode MessagePack body")
+	}
+	encoded, err := json.Marshal(v)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode JSON body")
+	}
+	imr.WithRawData(encoded, JSONContentType)
+	return nil
+}
+
+// HasDuplicateQueryKeys returns the sorted querystring keys which were given more than
+// once to WithHTTPExtension and are still in the querystring
+func (imr *InvokeMethodRequest) HasDuplicateQueryKeys() []string {
+	qs := imr.r.GetMessage().GetHttpExtension().GetQuerystring()
+	var keys []string
+	for _, k := range imr.metadataValues(duplicateQueryKeysHeader) {
+		if _, ok := qs[k]; ok {
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}
+
+// WithResponseContentType sets the response content type the caller wants regardless of accept
+func (imr *InvokeMethodRequest) WithResponseContentType(ct string) *InvokeMethodRequest {
+	imr.setMetadataValue(responseContentTypeHeader, ct)
+	return imr
+}
+
+// ResponseContentType returns the preferred response content type, and false if it is unset
+func (imr *InvokeMethodRequest) ResponseContentType() (string, bool) {
+	return imr.metadataValue(responseContentTypeHeader)
+}
+
+// WithFallbackMethod sets the method the resiliency layer invokes instead on timeout
+func (imr *InvokeMethodRequest) WithFallbackMethod(method string) *InvokeMethodRequest {
+	imr.setMetadataValue(fallbackMethodHeader, method)
+	return imr
+}
+
+// FallbackMethod returns the fallback method, and false if it is unset
+func (imr *InvokeMethodRequest) FallbackMethod() (string, bool) {
+	return imr.metadataValue(fallbackMethodHeader)
+}
+
+// EnsureContentType sets content_type by sniffing the body only if content_type is
+// empty and the body is given
+func (imr *InvokeMethodRequest) EnsureContentType() *InvokeMethodRequest {
+	m := imr.r.Message
+	if m.GetContentType() != "" || len(m.GetData().GetValue()) == 0 {
+		return imr
+	}
+	m.ContentType = http.DetectContentType(m.GetData().GetValue())
+	return imr
+}
+
+// ValidateActorIDUUID returns an error if the actor id is not a valid UUID. Requests
+// without an actor are not validated.
+func (imr *InvokeMethodRequest) ValidateActorIDUUID() error {
+	actor := imr.r.GetActor()
+	if actor == nil {
+		return nil
+	}
+	if _, err := uuid.Parse(actor.GetActorId()); err != nil {
+		return errors.Wrapf(err, "actor id %s is not a valid UUID", actor.GetActorId())
+	}
+	return nil
+}
+
+// WithTraceLinks sets the span links of the request. Each link is serialized as
+// traceid-spanid-type and the link attributes are not propagated.
+func (imr *InvokeMethodRequest) WithTraceLinks(links []trace.Link) *InvokeMethodRequest {
+	encoded := make([]string, 0, len(links))
+	for _, link := range links {
+		encoded = append(encoded, fmt.Sprintf("%x-%x-%d", link.TraceID[:], link.SpanID[:], link.Type))
+	}
+	imr.setMetadataValue(traceLinksHeader, strings.Join(encoded, ","))
+	return imr
+}
+
+// TraceLinks returns the span links of the request, skipping malformed entries
+func (imr *InvokeMethodRequest) TraceLinks() []trace.Link {
+	val, ok := imr.metadataValue(traceLinksHeader)
+	if !ok || val == "" {
+		return nil
+	}
+
+	var links []trace.Link
+	for _, entry := range strings.Split(val, ",") {
+		sections := strings.Split(strings.TrimSpace(entry), "-")
+		if len(sections) != 3 || len(sections[0]) != 32 || len(sections[1]) != 16 {
+			continue
+		}
+		tid, err := hex.DecodeString(sections[0])
+		if err != nil {
+			continue
+		}
+		sid, err := hex.DecodeString(sections[1])
+		if err != nil {
+			continue
+		}
+		linkType, err := strconv.Atoi(sections[2])
+		if err != nil {
+			continue
+		}
+
+		link := trace.Link{Type: trace.LinkType(linkType)}
+		copy(link.TraceID[:], tid)
+		copy(link.SpanID[:], sid)
+		links = append(links, link)
+	}
+	return links
+}
+
+// ValidateMetadataEncoding returns an error if a non-binary metadata key contains
+// non-ASCII characters or a metadata value is not valid UTF-8
+func (imr *InvokeMethodRequest) ValidateMetadataEncoding() error {
+	for k, listVal := range imr.r.GetMetadata() {
+		if strings.HasSuffix(k, gRPCBinaryMetadataSuffix) {
+			continue
+		}
+		for i := 0; i < len(k); i++ {
+			if k[i] >= utf8.RuneSelf {
+				return errors.Errorf("metadata key %q contains non-ASCII characters", k)
+			}
+		}
+		for _, val := range listVal.GetValues() {
+			if !utf8.ValidString(val) {
+				return errors.Errorf("metadata value of %q is not valid UTF-8", k)
+			}
+		}
+	}
+	return nil
+}
+
+// NormalizedVerb returns the canonical uppercase verb set by WithHTTPExtension, and
+// POST if no verb is set, as WithHTTPExtension does for unknown verbs
+func (imr *InvokeMethodRequest) NormalizedVerb() string {
+	verb := imr.r.GetMessage().GetHttpExtension().GetVerb()
+	if verb == commonv1pb.HTTPExtension_NONE {
+		return commonv1pb.HTTPExtension_POST.String()
+	}
+	return verb.String()
+}
+
+// WithClientSendTime sets the time the client sent the request
+func (imr *InvokeMethodRequest) WithClientSendTime(t time.Time) *InvokeMethodRequest {
+	imr.setMetadataValue(clientSendTimeHeader, t.UTC().Format(time.RFC3339Nano))
+	return imr
+}
+
+// QueueTime returns the time elapsed between the client send time and now, and false
+// if the client send time is unset or malformed
+func (imr *InvokeMethodRequest) QueueTime(now time.Time) (time.Duration, bool) {
+	val, ok := imr.metadataValue(clientSendTimeHeader)
+	if !ok {
+		return 0, false
+	}
+	sent, err := time.Parse(time.RFC3339Nano, val)
+	if err != nil {
+		return 0, false
+	}
+	return now.Sub(sent), true
+}
+
+// DPoPProof returns the DPoP proof JWT, and false if the header is absent or is not
+// made of three base64url encoded segments
+func (imr *InvokeMethodRequest) DPoPProof() (string, bool) {
+	proof, ok := imr.metadataValue(dpopHeader)
+	if !ok {
+		return "", false
+	}
+	if _, ok := jwtSegments(proof); !ok {
+		return "", false
+	}
+	return proof, true
+}
+
+// MapQueryValues replaces each querystring value with the result of fn. Keys for which
+// fn returns an empty string are removed.
+func (imr *InvokeMethodRequest) MapQueryValues(fn func(key, value string) string) *InvokeMethodRequest {
+	qs := imr.r.GetMessage().GetHttpExtension().GetQuerystring()
+	for k, v := range qs {
+		if mapped := fn(k, v); mapped != "" {
+			qs[k] = mapped
+		} else {
+			delete(qs, k)
+		}
+	}
+	return imr
+}
+
+// WithShardKey sets the shard key used to route the request consistently
+func (imr *InvokeMethodRequest) WithShardKey(key string) *InvokeMethodRequest {
+	imr.setMetadataValue(shardKeyHeader, key)
+	return imr
+}
+
+// ShardKey returns the shard key, and false if it is unset
+func (imr *InvokeMethodRequest) ShardKey() (string, bool) {
+	return imr.metadataValue(shardKeyHeader)
+}
+
+// IsServerWideOptions returns true for OPTIONS * requests which ask for the capabilities
+// of the server rather than of a method
+func (imr *InvokeMethodRequest) IsServerWideOptions() bool {
+	m := imr.r.GetMessage()
+	return m.GetHttpExtension().GetVerb() == commonv1pb.HTTPExtension_OPTIONS && m.GetMethod() == "*"
+}
+
+// WithSagaID sets the id of the saga the request belongs to
+func (imr *InvokeMethodRequest) WithSagaID(id string) *InvokeMethodRequest {
+	imr.setMetadataValue(sagaIDHeader, id)
+	return imr
+}
+
+// SagaID returns the saga id, and false if it is unset
+func (imr *InvokeMethodRequest) SagaID() (string, bool) {
+	return imr.metadataValue(sagaIDHeader)
+}
+
+// WithCompensation marks the request as the compensating step of the saga
+func (imr *InvokeMethodRequest) WithCompensation(compensation bool) *InvokeMethodRequest {
+	if compensation {
+		imr.setMetadataValue(sagaCompensationHeader, "true")
+	} else {
+		imr.deleteMetadata(sagaCompensationHeader)
+	}
+	return imr
+}
+
+// IsCompensation returns true if the request is the compensating step of the saga
+func (imr *InvokeMethodRequest) IsCompensation() bool {
+	val, _ := imr.metadataValue(sagaCompensationHeader)
+	compensation, _ := strconv.ParseBool(val)
+	return compensation
+}
+
+// NeedsBase64Transport returns true if the body has a non-textual content type and
+// must be base64 encoded to travel over a JSON only channel
+func (imr *InvokeMethodRequest) NeedsBase64Transport() bool {
+	contentType, data := imr.RawData()
+	if len(data) == 0 {
+		return false
+	}
+	return !isTextualContentType(contentType)
+}
+
+// WrapBase64 base64 encodes the body to carry it over a JSON only channel. The original
+// content_type is preserved in a reserved header and restored by DecodeTransferEncoding.
+func (imr *InvokeMethodRequest) WrapBase64() error {
+	if val, ok := imr.metadataValue(contentTransferEncodingHeader); ok && strings.EqualFold(val, "base64") {
+		return errors.New("body is already base64 encoded")
+	}
+	contentType, data := imr.RawData()
+	encoded := base64.StdEncoding.EncodeToString(data)
+
+	imr.setMetadataValue(contentTransferEncodingHeader, "base64")
+	imr.setMetadataValue(originalContentTypeHeader, contentType)
+	imr.WithRawData([]byte(encoded), "text/plain")
+	return nil
+}
+
+// DecodeTransferEncoding decodes the body wrapped by WrapBase64 and restores content_type
+func (imr *InvokeMethodRequest) DecodeTransferEncoding() error {
+	val, ok := imr.metadataValue(contentTransferEncodingHeader)
+	if !ok || !strings.EqualFold(val, "base64") {
+		return nil
+	}
+	_, data := imr.RawData()
+	decoded, err := base64.StdEncoding.DecodeString(string(data))
+	if err != nil {
+		return errors.Wrap(err, "failed to decode base64 body")
+	}
+	contentType, _ := imr.metadataValue(originalContentTypeHeader)
+
+	imr.deleteMetadata(contentTransferEncodingHeader)
+	imr.deleteMetadata(originalContentTypeHeader)
+	imr.r.Message.ContentType = contentType
+	imr.r.Message.Data = &any.Any{Value: decoded}
+	return nil
+}
+
+// WithFeatureFlags sets the feature flags the target app can branch on
+func (imr *InvokeMethodRequest) WithFeatureFlags(flags map[string]string) *InvokeMethodRequest {
+	imr.setMetadataValue(featureFlagsHeader, encodeHeaderMap(flags))
+	return imr
+}
+
+// FeatureFlags returns the feature flags of the request
+func (imr *InvokeMethodRequest) FeatureFlags() map[string]string {
+	val, _ := imr.metadataValue(featureFlagsHeader)
+	return decodeHeaderMap(val)
+}
+
+// IdempotencyKey returns the idempotency key of the request, and false if it is unset
+func (imr *InvokeMethodRequest) IdempotencyKey() (string, bool) {
+	key, ok := imr.metadataValue(idempotencyKeyHeader)
+	if !ok || key == "" {
+		return "", false
+	}
+	return key, true
+}
+
+// IsReplay returns true if the idempotency key of the request has already been seen.
+// Requests without an idempotency key are never replays.
+func (imr *InvokeMethodRequest) IsReplay(seen func(key string) bool) bool {
+	key, ok := imr.IdempotencyKey()
+	if !ok {
+		return false
+	}
+	return seen(key)
+}
+
+// SigningPayload builds a deterministic payload for audit signing from the method, the
+// verb, the sorted querystring, the sorted selected headers and the SHA256 of the body.
+// It returns an error if one of the selected headers is missing.
+func (imr *InvokeMethodRequest) SigningPayload(includeHeaders []string) ([]byte, error) {
+	names := make([]string, 0, len(includeHeaders))
+	for _, hdr := range includeHeaders {
+		names = append(names, strings.ToLower(hdr))
+	}
+	sort.Strings(names)
+
+	var b bytes.Buffer
+	b.WriteString(imr.r.GetMessage().GetMethod() + "\n")
+	b.WriteString(imr.NormalizedVerb() + "\n")
+	b.WriteString(imr.EncodeHTTPQueryString() + "\n")
+	for i, name := range names {
+		if i > 0 && names[i-1] == name {
+			continue
+		}
+		values := imr.metadataValues(name)
+		if len(values) == 0 {
+			return nil, errors.Errorf("signed header %s is missing", name)
+		}
+		b.WriteString(name + ":" + strings.Join(values, ",") + "\n")
+	}
+	bodyHash := sha256.Sum256(imr.r.GetMessage().GetData().GetValue())
+	b.WriteString(hex.EncodeToString(bodyHash[:]))
+	return b.Bytes(), nil
+}
+
+// DetectSmuggling returns an error if both content-length and transfer-encoding are set,
+// which is ambiguous and can be used for request smuggling
+func (imr *InvokeMethodRequest) DetectSmuggling() error {
+	_, hasContentLength := imr.metadataValue(contentLengthHeader)
+	_, hasTransferEncoding := imr.metadataValue(transferEncodingHeader)
+	if hasContentLength && hasTransferEncoding {
+		return errors.New("both content-length and transfer-encoding are set")
+	}
+	return nil
+}
+
+// WithPriority sets the numeric priority of the request
+func (imr *InvokeMethodRequest) WithPriority(priority int) *InvokeMethodRequest {
+	imr.setMetadataValue(priorityHeader, strconv.Itoa(priority))
+	return imr
+}
+
+// Priority returns the numeric priority, and false if it is unset or malformed
+func (imr *InvokeMethodRequest) Priority() (int, bool) {
+	val, ok := imr.metadataValue(priorityHeader)
+	if !ok {
+		return 0, false
+	}
+	priority, err := strconv.Atoi(val)
+	if err != nil {
+		return 0, false
+	}
+	return priority, true
+}
+
+// WithPriorityClass sets the priority of the request from one of the low, normal, high
+// and critical classes. Unknown classes fall back to normal.
+func (imr *InvokeMethodRequest) WithPriorityClass(class string) *InvokeMethodRequest {
+	priority, ok := priorityClasses[strings.ToLower(class)]
+	if !ok {
+		priority = priorityClasses[defaultPriorityClass]
+	}
+	return imr.WithPriority(priority)
+}
+
+// PriorityClass returns the class name of the request priority, and normal if the
+// priority is unset or does not match a class
+func (imr *InvokeMethodRequest) PriorityClass() string {
+	priority, ok := imr.Priority()
+	if !ok {
+		return defaultPriorityClass
+	}
+	for class, p := range priorityClasses {
+		if p == priority {
+			return class
+		}
+	}
+	return defaultPriorityClass
+}
+
+// CrossesTrustBoundary returns true if the request comes from outside of the mesh
+// without a mesh identity, so that the authz layer can require stronger authentication.
+// The origin and mesh identity headers of external callers are removed by StripTrustHeaders.
+func (imr *InvokeMethodRequest) CrossesTrustBoundary() bool {
+	if imr.Origin() == OriginInternal {
+		return false
+	}
+	identity, _ := imr.metadataValue(meshIdentityHeader)
+	return identity == ""
+}
+
+// WithStatusHint sets the gRPC status code hint of the request
+func (imr *InvokeMethodRequest) WithStatusHint(grpcCode codes.Code) *InvokeMethodRequest {
+	imr.setMetadataValue(statusHintHeader, strconv.Itoa(int(grpcCode)))
+	return imr
+}
+
+// StatusHint returns the gRPC status code hint, and false if it is unset or malformed
+func (imr *InvokeMethodRequest) StatusHint() (codes.Code, bool) {
+	val, ok := imr.metadataValue(statusHintHeader)
+	if !ok {
+		return codes.OK, false
+	}
+	code, err := strconv.ParseUint(val, 10, 32)
+	if err != nil {
+		return codes.OK, false
+	}
+	return codes.Code(code), true
+}
+
+// HTTPStatusHint returns the HTTP status mapped from the gRPC status code hint
+func (imr *InvokeMethodRequest) HTTPStatusHint() (int, bool) {
+	code, ok := imr.StatusHint()
+	if !ok {
+		return 0, false
+	}
+	return HTTPStatusFromCode(code), true
+}
+
+// ExtractTenantFromPath splits a method of the form t/{tenant}/{rest} into the tenant and
+// the remaining method. It returns false if the method has no valid tenant prefix.
+func (imr *InvokeMethodRequest) ExtractTenantFromPath() (tenant string, rest string, ok bool) {
+	method := imr.r.GetMessage().GetMethod()
+	trimmed := strings.TrimPrefix(method, "/")
+	if !strings.HasPrefix(trimmed, "t/") {
+		return "", method, false
+	}
+
+	parts := strings.SplitN(strings.TrimPrefix(trimmed, "t/"), "/", 2)
+	if parts[0] == "" {
+		return "", method, false
+	}
+	if len(parts) == 2 {
+		rest = parts[1]
+	}
+	return parts[0], rest, true
+}
+
+// IncrementHopCount increments the hop count of the request and returns it. It returns
+// an error if the incremented hop count exceeds max.
+func (imr *InvokeMethodRequest) IncrementHopCount(max int) (int, error) {
+	hops := 0
+	if val, ok := imr.metadataValue(hopCountHeader); ok {
+		var err error
+		if hops, err = strconv.Atoi(val); err != nil || hops < 0 {
+			return 0, errors.Errorf("invalid hop count %s", val)
+		}
+	}
+
+	hops++
+	if hops > max {
+		return hops, errors.Errorf("hop count %d exceeds the max of %d", hops, max)
+	}
+	imr.setMetadataValue(hopCountHeader, strconv.Itoa(hops))
+	return hops, nil
+}
+
+// PseudoHeaders returns the HTTP/2 pseudo-headers of the request for authority.
+// Requests without a verb are sent as POST.
+func (imr *InvokeMethodRequest) PseudoHeaders(authority string) map[string]string {
+	verb := imr.NormalizedVerb()
+	path := "/" + strings.TrimPrefix(imr.r.GetMessage().GetMethod(), "/")
+	if qs := imr.EncodeHTTPQueryString(); qs != "" {
+		path += "?" + qs
+	}
+
+	return map[string]string{
+		":method":    verb,
+		":path":      path,
+		":authority": authority,
+		":scheme":    "http",
+	}
+}
+
+// WebhookSignature returns the value of the signature header, e.g. x-hub-signature-256,
+// and false if it is absent
+func (imr *InvokeMethodRequest) WebhookSignature(headerKey string) (string, bool) {
+	sig, ok := imr.metadataValue(headerKey)
+	if !ok || sig == "" {
+		return "", false
+	}
+	return sig, true
+}
+
+// VerifyWebhookHMAC compares the hex encoded HMAC-SHA256 signature of the header with
+// the HMAC of the body computed with secret. An optional sha256= prefix is accepted.
+func (imr *InvokeMethodRequest) VerifyWebhookHMAC(headerKey string, secret []byte) error {
+	sig, ok := imr.WebhookSignature(headerKey)
+	if !ok {
+		return errors.Errorf("webhook signature header %s is missing", headerKey)
+	}
+	expected, err := hex.DecodeString(strings.TrimPrefix(sig, "sha256="))
+	if err != nil {
+		return errors.Wrap(err, "failed to decode webhook signature")
+	}
+
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(imr.r.GetMessage().GetData().GetValue())
+	if !hmac.Equal(expected, mac.Sum(nil)) {
+		return errors.New("webhook signature does not match the body")
+	}
+	return nil
+}
+
+// CollapseSlashes replaces repeated slashes of the method with a single one
+func (imr *InvokeMethodRequest) CollapseSlashes() *InvokeMethodRequest {
+	m := imr.r.Message
+	if !strings.Contains(m.GetMethod(), "//") {
+		return imr
+	}
+
+	var b strings.Builder
+	prevSlash := false
+	for _, c := range m.GetMethod() {
+		if c == '/' && prevSlash {
+			continue
+		}
+		prevSlash = c == '/'
+		b.WriteRune(c)
+	}
+	m.Method = b.String()
+	return imr
+}
+
+// WithLogLevel sets the log level override of the request, one of debug, info, warn and error
+func (imr *InvokeMethodRequest) WithLogLevel(level string) *InvokeMethodRequest {
+	imr.setMetadataValue(logLevelHeader, strings.ToLower(level))
+	return imr
+}
+
+// LogLevel returns the log level override, and false if it is unset or not a known level
+func (imr *InvokeMethodRequest) LogLevel() (string, bool) {
+	level, _ := imr.metadataValue(logLevelHeader)
+	switch level {
+	case "debug", "info", "warn", "error":
+		return level, true
+	}
+	return "", false
+}
+
+// StripInternalHeaders removes the internal Dapr routing headers and the context values
+// before the request is delivered to the app, keeping the user headers and the trace context
+func (imr *InvokeMethodRequest) StripInternalHeaders() *InvokeMethodRequest {
+	for _, hdr := range internalHeaders {
+		imr.deleteMetadata(hdr)
+	}
+	for k := range imr.r.GetMetadata() {
+		if strings.HasPrefix(strings.ToLower(k), contextValuePrefix) {
+			delete(imr.r.Metadata, k)
+		}
+	}
+	return imr
+}
+
+// WithDeploymentColor sets the blue/green deployment the request must be routed to
+func (imr *InvokeMethodRequest) WithDeploymentColor(color string) *InvokeMethodRequest {
+	imr.setMetadataValue(deploymentColorHeader, strings.ToLower(color))
+	return imr
+}
+
+// DeploymentColor returns the deployment color, and false if it is unset or neither blue nor green
+func (imr *InvokeMethodRequest) DeploymentColor() (string, bool) {
+	color, _ := imr.metadataValue(deploymentColorHeader)
+	if color != "blue" && color != "green" {
+		return "", false
+	}
+	return color, true
+}
+
+// WantsProblemJSON returns true if accept includes application/problem+json so that
+// errors are formatted as RFC 7807 problem details
+func (imr *InvokeMethodRequest) WantsProblemJSON() bool {
+	for _, val := range imr.metadataValues(acceptHeader) {
+		for _, mediaRange := range strings.Split(val, ",") {
+			mediaType := strings.TrimSpace(strings.SplitN(mediaRange, ";", 2)[0])
+			if strings.EqualFold(mediaType, "application/problem+json") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ODataFilters returns the $filter, $top, $skip and $orderby query options keyed by
+// their name without the $ prefix. It returns an error if $top or $skip is not a
+// non-negative integer.
+func (imr *InvokeMethodRequest) ODataFilters() (map[string]string, error) {
+	qs := imr.r.GetMessage().GetHttpExtension().GetQuerystring()
+	filters := map[string]string{}
+	for _, option := range []string{"filter", "top", "skip", "orderby"} {
+		val, ok := qs["$"+option]
+		if !ok {
+			continue
+		}
+		if option == "top" || option == "skip" {
+			if n, err := strconv.Atoi(val); err != nil || n < 0 {
+				return nil, errors.Errorf("invalid $%s value %s", option, val)
+			}
+		}
+		filters[option] = val
+	}
+	return filters, nil
+}
+
+// AcceptVersion parses the first versioned vendor media type of accept, e.g.
+// application/vnd.acme.v3+json returns acme, 3 and json
+func (imr *InvokeMethodRequest) AcceptVersion() (vendor, version, format string, ok bool) {
+	for _, val := range imr.metadataValues(acceptHeader) {
+		for _, mediaRange := range strings.Split(val, ",") {
+			mediaType := strings.ToLower(strings.TrimSpace(strings.SplitN(mediaRange, ";", 2)[0]))
+			if matches := vendorMediaTypeRegex.FindStringSubmatch(mediaType); matches != nil {
+				return matches[1], matches[2], matches[3], true
+			}
+		}
+	}
+	return "", "", "", false
+}
+
+// WithLockToken sets the lock token the target validates before serving the request
+func (imr *InvokeMethodRequest) WithLockToken(token string) *InvokeMethodRequest {
+	imr.setMetadataValue(lockTokenHeader, token)
+	return imr
+}
+
+// LockToken returns the lock token, and false if it is unset
+func (imr *InvokeMethodRequest) LockToken() (string, bool) {
+	return imr.metadataValue(lockTokenHeader)
+}
+
+// DebugDump returns a human readable dump of the request with the method, the verb, the API
+// version, the headers with sensitive values redacted and a hex dump of up to maxBody
+// bytes of the body
+func (imr *InvokeMethodRequest) DebugDump(maxBody int) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "method: %s\n", imr.r.GetMessage().GetMethod())
+	fmt.Fprintf(&b, "verb: %s\n", imr.r.GetMessage().GetHttpExtension().GetVerb())
+	fmt.Fprintf(&b, "version: %s\n", imr.r.GetVer())
+
+	md := imr.r.GetMetadata()
+	keys := make([]string, 0, len(md))
+	for k := range md {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	b.WriteString("headers:\n")
+	for _, k := range keys {
+		val := strings.Join(md[k].GetValues(), ", ")
+		if isSensitiveHeader(k) {
+			val = redactedValue
+		}
+		fmt.Fprintf(&b, "  %s: %s\n", k, val)
+	}
+
+	contentType, data := imr.RawData()
+	fmt.Fprintf(&b, "body: %d bytes, content-type: %s\n", len(data), contentType)
+	if maxBody < 0 {
+		maxBody = 0
+	}
+	if len(data) > maxBody {
+		b.WriteString(hex.Dump(data[:maxBody]))
+		fmt.Fprintf(&b, "... %d more bytes\n", len(data)-maxBody)
+	} else {
+		b.WriteString(hex.Dump(data))
+	}
+	return b.String()
+}
+
+// WithConsistency sets the read consistency level of the request, eventual or strong
+func (imr *InvokeMethodRequest) WithConsistency(level string) *InvokeMethodRequest {
+	imr.setMetadataValue(consistencyHeader, strings.ToLower(level))
+	return imr
+}
+
+// Consistency returns the read consistency level, and false if it is unset or unknown
+func (imr *InvokeMethodRequest) Consistency() (string, bool) {
+	level, _ := imr.metadataValue(consistencyHeader)
+	if level != "eventual" && level != "strong" {
+		return "", false
+	}
+	return level, true
+}
+
+// SupportsResume returns true if the caller advertises resumable uploads
+func (imr *InvokeMethodRequest) SupportsResume() bool {
+	val, ok := imr.metadataValue(resumeHeader)
+	if !ok {
+		return false
+	}
+	if val == "" {
+		return true
+	}
+	supported, err := strconv.ParseBool(val)
+	return err == nil && supported
+}
+
+// WithBypassCircuitBreaker marks the request to be sent even when the circuit is open
+func (imr *InvokeMethodRequest) WithBypassCircuitBreaker() *InvokeMethodRequest {
+	imr.setMetadataValue(bypassCircuitBreakerHeader, "true")
+	return imr
+}
+
+// BypassCircuitBreaker returns true if the circuit breaker checks must be skipped
+func (imr *InvokeMethodRequest) BypassCircuitBreaker() bool {
+	val, _ := imr.metadataValue(bypassCircuitBreakerHeader)
+	bypass, _ := strconv.ParseBool(val)
+	return bypass
+}
+
+// StructuredField parses the header key as an RFC 8941 structured field value. Multiple
+// header values are combined as a comma separated list.
+func (imr *InvokeMethodRequest) StructuredField(key string) (*SFValue, error) {
+	values := imr.metadataValues(key)
+	if len(values) == 0 {
+		return nil, errors.Errorf("header %s is missing", key)
+	}
+	return ParseStructuredField(strings.Join(values, ", "))
+}
+
+// FairQueueKey returns the key of the per-tenant queue made of the tenant of the method
+// path and the priority class, e.g. acme/high
+func (imr *InvokeMethodRequest) FairQueueKey() string {
+	tenant, _, ok := imr.ExtractTenantFromPath()
+	if !ok {
+		tenant = defaultFairQueueTenant
+	}
+	return tenant + "/" + imr.PriorityClass()
+}
+
+// RequestedSchema returns the response schema the caller asks for, and false if it is unset
+func (imr *InvokeMethodRequest) RequestedSchema() (string, bool) {
+	schema, ok := imr.metadataValue(responseSchemaHeader)
+	if !ok || schema == "" {
+		return "", false
+	}
+	return schema, true
+}
+
+// StripProxyPrefix removes prefix, e.g. /api/, from the method if the method starts with it
+func (imr *InvokeMethodRequest) StripProxyPrefix(prefix string) *InvokeMethodRequest {
+	prefix = strings.Trim(prefix, "/")
+	if prefix == "" {
+		return imr
+	}
+
+	m := imr.r.Message
+	method := strings.TrimPrefix(m.GetMethod(), "/")
+	if method == prefix {
+		m.Method = ""
+	} else if strings.HasPrefix(method, prefix+"/") {
+		m.Method = strings.TrimPrefix(method, prefix+"/")
+	}
+	return imr
+}
+
+// WithDataRegion restricts the routing of the request to targets in region
+func (imr *InvokeMethodRequest) WithDataRegion(region string) *InvokeMethodRequest {
+	imr.setMetadataValue(dataRegionHeader, region)
+	return imr
+}
+
+// DataRegion returns the data residency region, and false if it is unset
+func (imr *InvokeMethodRequest) DataRegion() (string, bool) {
+	region, ok := imr.metadataValue(dataRegionHeader)
+	if !ok || region == "" {
+		return "", false
+	}
+	return region, true
+}
+
+// IsActorDeactivation returns true for DELETE calls to actors/{type}/{id}, the route
+// used to deactivate an actor
+func (imr *InvokeMethodRequest) IsActorDeactivation() bool {
+	m := imr.r.GetMessage()
+	if m.GetHttpExtension().GetVerb() != commonv1pb.HTTPExtension_DELETE {
+		return false
+	}
+	segments := strings.Split(strings.Trim(m.GetMethod(), "/"), "/")
+	return len(segments) == 3 && segments[0] == "actors" && segments[1] != "" && segments[2] != ""
+}
+
+// WithContextValue sets a context value which travels with the request between
+// middlewares and sidecars but is never delivered to the app, as the app channels
+// call StripInternalHeaders before delivery
+func (imr *InvokeMethodRequest) WithContextValue(key, value string) *InvokeMethodRequest {
+	imr.setMetadataValue(contextValuePrefix+key, value)
+	return imr
+}
+
+// ContextValue returns the context value of key, and false if it is unset
+func (imr *InvokeMethodRequest) ContextValue(key string) (string, bool) {
+	return imr.metadataValue(contextValuePrefix + key)
+}
+
+// KeepAliveParams returns the timeout and max parameters of the keep-alive header.
+// Parameters missing from the header are returned as 0, and ok is false if the header
+// is absent or malformed.
+func (imr *InvokeMethodRequest) KeepAliveParams() (timeout int, max int, ok bool) {
+	val, found := imr.metadataValue(keepAliveHeader)
+	if !found {
+		return 0, 0, false
+	}
+	for _, param := range strings.Split(val, ",") {
+		param = strings.TrimSpace(param)
+		if param == "" {
+			continue
+		}
+		kv := strings.SplitN(param, "=", 2)
+		if len(kv) != 2 {
+			return 0, 0, false
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(kv[1]))
+		if err != nil || n < 0 {
+			return 0, 0, false
+		}
+		switch strings.ToLower(strings.TrimSpace(kv[0])) {
+		case "timeout":
+			timeout = n
+		case "max":
+			max = n
+		}
+	}
+	return timeout, max, true
+}
+
+// WithSamplingRatio overrides the trace sampling ratio of the request. The ratio is
+// clamped to [0, 1]. NaN is rejected and removes the override.
+func (imr *InvokeMethodRequest) WithSamplingRatio(r float64) *InvokeMethodRequest {
+	if math.IsNaN(r) {
+		imr.deleteMetadata(samplingRatioHeader)
+		return imr
+	}
+	r = math.Max(0, math.Min(1, r))
+	imr.setMetadataValue(samplingRatioHeader, strconv.FormatFloat(r, 'f', -1, 64))
+	return imr
+}
+
+// SamplingRatio returns the trace sampling ratio override, and false if it is unset or
+// is not within [0, 1]
+func (imr *InvokeMethodRequest) SamplingRatio() (float64, bool) {
+	val, ok := imr.metadataValue(samplingRatioHeader)
+	if !ok {
+		return 0, false
+	}
+	r, err := strconv.ParseFloat(val, 64)
+	if err != nil || math.IsNaN(r) || r < 0 || r > 1 {
+		return 0, false
+	}
+	return r, true
+}
+
+// ValidateHost returns an error if the host or :authority header is absent or not in
+// allowed. Allowed entries without a port match the host on any port.
+func (imr *InvokeMethodRequest) ValidateHost(allowed ...string) error {
+	host, ok := imr.metadataValue("host")
+	if !ok {
+		host, ok = imr.metadataValue(":authority")
+	}
+	if !ok || host == "" {
+		return errors.New("request has no host")
+	}
+
+	hostname := host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		hostname = h
+	}
+	for _, a := range allowed {
+		if strings.EqualFold(a, host) || strings.EqualFold(a, hostname) {
+			return nil
+		}
+	}
+	return errors.Errorf("host %s is not allowed", host)
+}
+
+// PackMetadata serializes the metadata to a compact protobuf blob which can be embedded
+// in another payload
+func (imr *InvokeMethodRequest) PackMetadata() ([]byte, error) {
+	return proto.Marshal(&internalv1pb.InternalInvokeRequest{Metadata: imr.r.GetMetadata()})
+}
+
+// UnpackMetadata replaces the metadata with the metadata of a blob created by PackMetadata
+func (imr *InvokeMethodRequest) UnpackMetadata(blob []byte) error {
+	packed := &internalv1pb.InternalInvokeRequest{}
+	if err := proto.Unmarshal(blob, packed); err != nil {
+		return errors.Wrap(err, "failed to unpack metadata")
+	}
+	imr.r.Metadata = packed.GetMetadata()
+	return nil
+}
+
+// MethodOrDefault returns def if the method is empty or the root path, and the method otherwise
+func (imr *InvokeMethodRequest) MethodOrDefault(def string) string {
+	method := imr.r.GetMessage().GetMethod()
+	if method == "" || method == "/" {
+		return def
+	}
+	return method
+}
+
+// WithSpanAttributes sets the attributes which the downstream sidecars add to their spans
+func (imr *InvokeMethodRequest) WithSpanAttributes(attrs map[string]string) *InvokeMethodRequest {
+	imr.setMetadataValue(spanAttributesHeader, encodeHeaderMap(attrs))
+	return imr
+}
+
+// SpanAttributes returns the span attributes of the request
+func (imr *InvokeMethodRequest) SpanAttributes() map[string]string {
+	val, _ := imr.metadataValue(spanAttributesHeader)
+	return decodeHeaderMap(val)
+}
+
+// TargetWeights returns the load balancing weights of the targets, and false if the
+// weights are unset or malformed
+func (imr *InvokeMethodRequest) TargetWeights() (map[string]int, bool) {
+	val, ok := imr.metadataValue(targetWeightsHeader)
+	if !ok {
+		return nil, false
+	}
+	weights := map[string]int{}
+	for _, spec := range strings.Split(val, ",") {
+		kv := strings.SplitN(strings.TrimSpace(spec), "=", 2)
+		if len(kv) != 2 || strings.TrimSpace(kv[0]) == "" {
+			return nil, false
+		}
+		weight, err := strconv.Atoi(strings.TrimSpace(kv[1]))
+		if err != nil || weight < 0 {
+			return nil, false
+		}
+		weights[strings.TrimSpace(kv[0])] = weight
+	}
+	return weights, true
+}
+
+// ValidateReentrancy ensures that actor calls carry a reentrancy id when reentrancy is
+// enabled, generating one if it is missing. The id is returned by ReentrancyID.
+func (imr *InvokeMethodRequest) ValidateReentrancy(enabled bool) error {
+	if !enabled || imr.r.GetActor() == nil {
+		return nil
+	}
+	id, ok := imr.metadataValue(reentrancyIDHeader)
+	if !ok {
+		imr.setMetadataValue(reentrancyIDHeader, uuid.New().String())
+		return nil
+	}
+	if strings.TrimSpace(id) == "" {
+		return errors.New("reentrancy id is empty")
+	}
+	return nil
+}
+
+// ReentrancyID returns the reentrancy id of the actor call, and false if it is unset
+func (imr *InvokeMethodRequest) ReentrancyID() (string, bool) {
+	return imr.metadataValue(reentrancyIDHeader)
+}
+
+// WithPayloadVersion sets the version of the payload schema, which is independent from
+// the internal API version
+func (imr *InvokeMethodRequest) WithPayloadVersion(v int) *InvokeMethodRequest {
+	imr.setMetadataValue(payloadVersionHeader, strconv.Itoa(v))
+	return imr
+}
+
+// PayloadVersion returns the version of the payload schema, and false if it is unset or malformed
+func (imr *InvokeMethodRequest) PayloadVersion() (int, bool) {
+	val, ok := imr.metadataValue(payloadVersionHeader)
+	if !ok {
+		return 0, false
+	}
+	v, err := strconv.Atoi(val)
+	if err != nil {
+		return 0, false
+	}
+	return v, true
+}
+
+// RejectNullBytes returns an error if a metadata key or value contains a null byte,
+// which truncates headers in some backends
+func (imr *InvokeMethodRequest) RejectNullBytes() error {
+	for k, listVal := range imr.r.GetMetadata() {
+		if strings.IndexByte(k, 0) >= 0 {
+			return errors.Errorf("metadata key %q contains a null byte", k)
+		}
+		for _, val := range listVal.GetValues() {
+			if strings.IndexByte(val, 0) >= 0 {
+				return errors.Errorf("metadata value of %q contains a null byte", k)
+			}
+		}
+	}
+	return nil
+}
+
+// Diff returns human readable differences in the method, verb, content type, body size
+// and metadata of the request and other, with the metadata differences sorted by key.
+// Every field and metadata key is only in this request if other is nil.
+func (imr *InvokeMethodRequest) Diff(other *InvokeMethodRequest) []string {
+	var diffs []string
+	diff := func(field string, a, b interface{}) {
+		if a != b {
+			diffs = append(diffs, fmt.Sprintf("%s: %v != %v", field, a, b))
+		}
+	}
+	if other == nil {
+		other = &InvokeMethodRequest{r: &internalv1pb.InternalInvokeRequest{}}
+		diff = func(field string, a, b interface{}) {
+			diffs = append(diffs, fmt.Sprintf("%s: only in this request", field))
+		}
+	}
+
+	diff("method", imr.r.GetMessage().GetMethod(), other.r.GetMessage().GetMethod())
+	diff("verb", imr.r.GetMessage().GetHttpExtension().GetVerb(), other.r.GetMessage().GetHttpExtension().GetVerb())
+	contentType, data := imr.RawData()
+	otherContentType, otherData := other.RawData()
+	diff("content type", contentType, otherContentType)
+	diff("body size", len(data), len(otherData))
+
+	keys := map[string]bool{}
+	for k := range imr.r.GetMetadata() {
+		keys[k] = true
+	}
+	for k := range other.r.GetMetadata() {
+		keys[k] = true
+	}
+	sortedKeys := make([]string, 0, len(keys))
+	for k := range keys {
+		sortedKeys = append(sortedKeys, k)
+	}
+	sort.Strings(sortedKeys)
+
+	for _, k := range sortedKeys {
+		a, inA := imr.r.GetMetadata()[k]
+		b, inB := other.r.GetMetadata()[k]
+		switch {
+		case !inB:
+			diffs = append(diffs, fmt.Sprintf("metadata %s: only in this request", k))
+		case !inA:
+			diffs = append(diffs, fmt.Sprintf("metadata %s: only in other request", k))
+		default:
+			diff("metadata "+k, strings.Join(a.GetValues(), ","), strings.Join(b.GetValues(), ","))
+		}
+	}
+	return diffs
+}
+
+// DeprecationInfo returns whether the API is deprecated according to the deprecation
+// header, and the sunset time of the sunset header (RFC 8594). The deprecation header
+// is either true or the HTTP date of the deprecation. ok is false if both headers are
+// absent or one of them is malformed.
+func (imr *InvokeMethodRequest) DeprecationInfo() (deprecated bool, sunset time.Time, ok bool) {
+	deprecation, hasDeprecation := imr.metadataValue(deprecationHeader)
+	sunsetVal, hasSunset := imr.metadataValue(sunsetHeader)
+	if !hasDeprecation && !hasSunset {
+		return false, time.Time{}, false
+	}
+
+	if hasDeprecation {
+		if parsed, err := strconv.ParseBool(deprecation); err == nil {
+			deprecated = parsed
+		} else if _, err := http.ParseTime(deprecation); err == nil {
+			deprecated = true
+		} else {
+			return false, time.Time{}, false
+		}
+	}
+	if hasSunset {
+		var err error
+		if sunset, err = http.ParseTime(sunsetVal); err != nil {
+			return false, time.Time{}, false
+		}
+	}
+	return deprecated, sunset, true
+}
+
+// WithBindingMetadata sets the input binding which triggered the request and the
+// metadata of the binding event
+func (imr *InvokeMethodRequest) WithBindingMetadata(bindingName string, md map[string]string) *InvokeMethodRequest {
+	imr.setMetadataValue(bindingNameHeader, bindingName)
+	imr.setMetadataValue(bindingMetadataHeader, encodeHeaderMap(md))
+	return imr
+}
+
+// BindingName returns the input binding which triggered the request, and false if it is unset
+func (imr *InvokeMethodRequest) BindingName() (string, bool) {
+	return imr.metadataValue(bindingNameHeader)
+}
+
+// BindingMetadata returns the metadata of the binding event
+func (imr *InvokeMethodRequest) BindingMetadata() map[string]string {
+	val, _ := imr.metadataValue(bindingMetadataHeader)
+	return decodeHeaderMap(val)
+}
+
+// IsScheduled returns true if the request was triggered by a cron binding
+func (imr *InvokeMethodRequest) IsScheduled() bool {
+	val, _ := imr.metadataValue(bindingTypeHeader)
+	return strings.EqualFold(strings.TrimSpace(val), "cron")
+}
+
+// WithDeadLetterTopic sets the topic where the runtime routes the failed deliveries of the request
+func (imr *InvokeMethodRequest) WithDeadLetterTopic(topic string) *InvokeMethodRequest {
+	imr.setMetadataValue(deadLetterTopicHeader, topic)
+	return imr
+}
+
+// DeadLetterTopic returns the dead letter topic, and false if it is unset
+func (imr *InvokeMethodRequest) DeadLetterTopic() (string, bool) {
+	return imr.metadataValue(deadLetterTopicHeader)
+}
+
+// PartitionKey hashes the value of headerKey into a stable partition in [0, partitions)
+// for ordered delivery. It returns false if the header is absent.
+func (imr *InvokeMethodRequest) PartitionKey(headerKey string, partitions int) (int, bool) {
+	val, ok := imr.metadataValue(headerKey)
+	if !ok || partitions <= 0 {
+		return 0, false
+	}
+	return hashBucket(val, partitions), true
+}
+
+// WithTTL sets the message to expire after d
+func (imr *InvokeMethodRequest) WithTTL(d time.Duration) *InvokeMethodRequest {
+	imr.setMetadataValue(expiryHeader, time.Now().Add(d).UTC().Format(time.RFC3339Nano))
+	return imr
+}
+
+// TTL returns the time left until the message expires, and false if the expiry is unset or malformed
+func (imr *InvokeMethodRequest) TTL() (time.Duration, bool) {
+	expiry, ok := imr.expiry()
+	if !ok {
+		return 0, false
+	}
+	return time.Until(expiry), true
+}
+
+// IsExpired returns true if the message expired at now
+func (imr *InvokeMethodRequest) IsExpired(now time.Time) bool {
+	expiry, ok := imr.expiry()
+	return ok && !now.Before(expiry)
+}
+
+// AckTokens returns the tokens of the acknowledged messages from the comma separated
+// ack tokens header
+func (imr *InvokeMethodRequest) AckTokens() []string {
+	var tokens []string
+	for _, val := range imr.metadataValues(ackTokensHeader) {
+		for _, token := range strings.Split(val, ",") {
+			if token = strings.TrimSpace(token); token != "" {
+				tokens = append(tokens, token)
+			}
+		}
+	}
+	return tokens
+}
+
+// WithSequence sets the sequence number of the message, which lets the target detect gaps
+func (imr *InvokeMethodRequest) WithSequence(seq uint64) *InvokeMethodRequest {
+	imr.setMetadataValue(sequenceHeader, strconv.FormatUint(seq, 10))
+	return imr
+}
+
+// Sequence returns the sequence number of the message, and false if it is unset or malformed
+func (imr *InvokeMethodRequest) Sequence() (uint64, bool) {
+	val, ok := imr.metadataValue(sequenceHeader)
+	if !ok {
+		return 0, false
+	}
+	seq, err := strconv.ParseUint(val, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return seq, true
+}
+
+// RedactedClone returns a deep copy of the request for event logs, with the body and the
+// values of the sensitive headers and of sensitiveKeys replaced by placeholders
+func (imr *InvokeMethodRequest) RedactedClone(sensitiveKeys ...string) *InvokeMethodRequest {
+	clone := &InvokeMethodRequest{
+		r: proto.Clone(imr.r).(*internalv1pb.InternalInvokeRequest),
+	}
+
+	for k, listVal := range clone.r.GetMetadata() {
+		sensitive := isSensitiveHeader(k)
+		for _, key := range sensitiveKeys {
+			sensitive = sensitive || strings.EqualFold(k, key)
+		}
+		if sensitive {
+			for i := range listVal.Values {
+				listVal.Values[i] = redactedValue
+			}
+		}
+	}
+	if data := clone.r.GetMessage().GetData(); len(data.GetValue()) > 0 {
+		data.Value = []byte(redactedValue)
+	}
+	return clone
+}
+
+// WithMaxConcurrency sets the maximum parallelism the target should use for the logical
+// operation of the request
+func (imr *InvokeMethodRequest) WithMaxConcurrency(n int) *InvokeMethodRequest {
+	imr.setMetadataValue(maxConcurrencyHeader, strconv.Itoa(n))
+	return imr
+}
+
+// MaxConcurrency returns the maximum parallelism, and false if it is unset or is not a
+// positive integer
+func (imr *InvokeMethodRequest) MaxConcurrency() (int, bool) {
+	val, ok := imr.metadataValue(maxConcurrencyHeader)
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.Atoi(val)
+	if err != nil || n <= 0 {
+		return 0, false
+	}
+	return n, true
+}
+
+// ValidateQueryParams returns an error if the querystring has a parameter which is not
+// in allowed
+func (imr *InvokeMethodRequest) ValidateQueryParams(allowed ...string) error {
+	allowedParams := make(map[string]bool, len(allowed))
+	for _, name := range allowed {
+		allowedParams[name] = true
+	}
+
+	var unexpected []string
+	for name := range imr.r.GetMessage().GetHttpExtension().GetQuerystring() {
+		if !allowedParams[name] {
+			unexpected = append(unexpected, name)
+		}
+	}
+	if len(unexpected) > 0 {
+		sort.Strings(unexpected)
+		return errors.Errorf("unexpected query parameters: %s", strings.Join(unexpected, ", "))
+	}
+	return nil
+}
+
+// TranscodeCharset transcodes the text body from the charset of its content_type, or
+// UTF-8 if it declares none, to the charset to and updates the charset of content_type
+func (imr *InvokeMethodRequest) TranscodeCharset(to string) error {
+	contentType, data := imr.RawData()
+	if !isTextualContentType(contentType) {
+		return errors.Errorf("content type %s is not text", contentType)
+	}
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return errors.Wrapf(err, "invalid content type %s", contentType)
+	}
+	from := params["charset"]
+	if from == "" {
+		from = "utf-8"
+	}
+
+	fromEnc, err := charsetEncoding(from)
+	if err != nil {
+		return err
+	}
+	toEnc, err := charsetEncoding(to)
+	if err != nil {
+		return err
+	}
+	decoded, err := fromEnc.NewDecoder().Bytes(data)
+	if err != nil {
+		return errors.Wrapf(err, "failed to decode body from %s", from)
+	}
+	encoded, err := toEnc.NewEncoder().Bytes(decoded)
+	if err != nil {
+		return errors.Wrapf(err, "failed to encode body to %s", to)
+	}
+
+	params["charset"] = to
+	imr.WithRawData(encoded, mime.FormatMediaType(mediaType, params))
+	return nil
+}
+
+// Warnings parses the warning header into the list of warnings. Parsing stops at the
+// first malformed warning.
+func (imr *InvokeMethodRequest) Warnings() []WarningHeader {
+	var warnings []WarningHeader
+	for _, val := range imr.metadataValues(warningHeader) {
+		warnings = append(warnings, parseWarnings(val)...)
+	}
+	return warnings
+}
+
+// ClientHints returns the sec-ch-* client hint headers keyed by their lowercase name.
+// Multiple values of a hint are comma separated.
+func (imr *InvokeMethodRequest) ClientHints() map[string]string {
+	hints := map[string]string{}
+	for k, listVal := range imr.r.GetMetadata() {
+		key := strings.ToLower(k)
+		if !strings.HasPrefix(key, clientHintPrefix) {
+			continue
+		}
+		values := listVal.GetValues()
+		if prev, ok := hints[key]; ok {
+			values = append([]string{prev}, values...)
+		}
+		hints[key] = strings.Join(values, ",")
+	}
+	return hints
+}
+
+// GraphQLOperation parses a JSON GraphQL request body, and returns false if the body is
+// not a GraphQL request
+func (imr *InvokeMethodRequest) GraphQLOperation() (query string, operationName string, variables map[string]interface{}, ok bool) {
+	_, data := imr.RawData()
+	var op struct {
+		Query         string                 `json:"query"`
+		OperationName string                 `json:"operationName"`
+		Variables     map[string]interface{} `json:"variables"`
+	}
+	if err := json.Unmarshal(data, &op); err != nil || strings.TrimSpace(op.Query) == "" {
+		return "", "", nil, false
+	}
+	return op.Query, op.OperationName, op.Variables, true
+}
+
+// WithCaseSensitiveRouting sets whether the router matches the method case-sensitively
+func (imr *InvokeMethodRequest) WithCaseSensitiveRouting(on bool) *InvokeMethodRequest {
+	if on {
+		imr.setMetadataValue(caseSensitiveRoutingHeader, "true")
+	} else {
+		imr.deleteMetadata(caseSensitiveRoutingHeader)
+	}
+	return imr
+}
+
+// CaseSensitiveRouting returns true if the router must match the method case-sensitively
+func (imr *InvokeMethodRequest) CaseSensitiveRouting() bool {
+	val, _ := imr.metadataValue(caseSensitiveRoutingHeader)
+	on, _ := strconv.ParseBool(val)
+	return on
+}
+
+// MetadataJSON unmarshals the JSON value of the metadata key into out
+func (imr *InvokeMethodRequest) MetadataJSON(key string, out interface{}) error {
+	val, ok := imr.metadataValue(key)
+	if !ok {
+		return errors.Errorf("metadata %s is not set", key)
+	}
+	if err := json.Unmarshal([]byte(val), out); err != nil {
+		return errors.Wrapf(err, "metadata %s is not valid JSON", key)
+	}
+	return nil
+}
+
+// WithCacheHint sets the response caching hint, stored as the cache-control directives
+// the channel sets on the response
+func (imr *InvokeMethodRequest) WithCacheHint(maxAge time.Duration, private bool) *InvokeMethodRequest {
+	scope := "public"
+	if private {
+		scope = "private"
+	}
+	imr.setMetadataValue(cacheHintHeader, fmt.Sprintf("%s, max-age=%d", scope, int64(maxAge/time.Second)))
+	return imr
+}
+
+// CacheHint returns the max age and scope of the response caching hint, and false if it
+// is unset or malformed
+func (imr *InvokeMethodRequest) CacheHint() (maxAge time.Duration, private bool, ok bool) {
+	val, found := imr.metadataValue(cacheHintHeader)
+	if !found {
+		return 0, false, false
+	}
+	for _, directive := range strings.Split(val, ",") {
+		kv := strings.SplitN(strings.TrimSpace(directive), "=", 2)
+		switch strings.ToLower(kv[0]) {
+		case "private":
+			private = true
+		case "max-age":
+			if len(kv) != 2 {
+				return 0, false, false
+			}
+			seconds, err := strconv.ParseInt(kv[1], 10, 64)
+			if err != nil || seconds < 0 {
+				return 0, false, false
+			}
+			maxAge = time.Duration(seconds) * time.Second
+			ok = true
+		}
+	}
+	return maxAge, private, ok
+}
+
+// LongPollTimeout returns the long-poll timeout of the wait or timeout query parameter,
+// given either as a duration like 30s or as seconds. It returns false if both are absent
+// or the value is invalid.
+func (imr *InvokeMethodRequest) LongPollTimeout() (time.Duration, bool) {
+	qs := imr.r.GetMessage().GetHttpExtension().GetQuerystring()
+	val, ok := qs["wait"]
+	if !ok {
+		val, ok = qs["timeout"]
+	}
+	if !ok {
+		return 0, false
+	}
+	if seconds, err := strconv.ParseUint(val, 10, 32); err == nil {
+		return time.Duration(seconds) * time.Second, true
+	}
+	d, err := time.ParseDuration(val)
+	if err != nil || d < 0 {
+		return 0, false
+	}
+	return d, true
+}
+
+// WithMinTLSVersion sets the minimum TLS version, such as tls.VersionTLS12, below which
+// the channel rejects the connection
+func (imr *InvokeMethodRequest) WithMinTLSVersion(v uint16) *InvokeMethodRequest {
+	imr.setMetadataValue(minTLSVersionHeader, strconv.FormatUint(uint64(v), 10))
+	return imr
+}
+
+// MinTLSVersion returns the minimum TLS version, and false if it is unset or malformed
+func (imr *InvokeMethodRequest) MinTLSVersion() (uint16, bool) {
+	val, ok := imr.metadataValue(minTLSVersionHeader)
+	if !ok {
+		return 0, false
+	}
+	v, err := strconv.ParseUint(val, 10, 16)
+	if err != nil {
+		return 0, false
+	}
+	return uint16(v), true
+}
+
+// AcceptedCharsets returns the lowercase charsets of accept-charset ordered by
+// decreasing q-value, leaving out the refused charsets with q=0
+func (imr *InvokeMethodRequest) AcceptedCharsets() []string {
+	charsets := parseQualityList(imr.metadataValues(acceptCharsetHeader))
+	for i := range charsets {
+		charsets[i] = strings.ToLower(charsets[i])
+	}
+	return charsets
+}
+
+// WithRetryPolicy overrides the retry policy of the request
+func (imr *InvokeMethodRequest) WithRetryPolicy(maxRetries int, backoff time.Duration) *InvokeMethodRequest {
+	policy, _ := json.Marshal(RetryPolicy{MaxRetries: maxRetries, Backoff: backoff})
+	imr.setMetadataValue(retryPolicyHeader, string(policy))
+	return imr
+}
+
+// RetryPolicy returns the retry policy override, and false if it is unset or malformed
+func (imr *InvokeMethodRequest) RetryPolicy() (RetryPolicy, bool) {
+	var policy RetryPolicy
+	val, ok := imr.metadataValue(retryPolicyHeader)
+	if !ok {
+		return policy, false
+	}
+	if err := json.Unmarshal([]byte(val), &policy); err != nil || policy.MaxRetries < 0 || policy.Backoff < 0 {
+		return RetryPolicy{}, false
+	}
+	return policy, true
+}
+
+// WithBreakGlass marks the request as an emergency access which bypasses the mesh
+// policies. An empty reason is rejected and leaves the request unmarked. The header is
+// removed from the requests of external callers by StripTrustHeaders.
+func (imr *InvokeMethodRequest) WithBreakGlass(reason string) *InvokeMethodRequest {
+	if reason = strings.TrimSpace(reason); reason == "" {
+		imr.deleteMetadata(breakGlassHeader)
+		return imr
+	}
+	imr.setMetadataValue(breakGlassHeader, reason)
+	return imr
+}
+
+// BreakGlass returns the reason of the emergency access, and false if the request is not
+// an emergency access
+func (imr *InvokeMethodRequest) BreakGlass() (string, bool) {
+	reason, _ := imr.metadataValue(breakGlassHeader)
+	if reason = strings.TrimSpace(reason); reason == "" {
+		return "", false
+	}
+	return reason, true
+}
+
+// WithSamplingKey sets the key which correlates the spans of the request for tail-based sampling
+func (imr *InvokeMethodRequest) WithSamplingKey(key string) *InvokeMethodRequest {
+	imr.setMetadataValue(samplingKeyHeader, key)
+	return imr
+}
+
+// SamplingKey returns the tail-based sampling key, and false if it is unset
+func (imr *InvokeMethodRequest) SamplingKey() (string, bool) {
+	return imr.metadataValue(samplingKeyHeader)
+}
+
+// StripTopologyHeaders removes the via, forwarded, x-forwarded-* and server headers
+// which would leak the internal topology before the request is delivered externally
+func (imr *InvokeMethodRequest) StripTopologyHeaders() *InvokeMethodRequest {
+	for _, hdr := range topologyHeaders {
+		imr.deleteMetadata(hdr)
+	}
+	for k := range imr.r.GetMetadata() {
+		if strings.HasPrefix(strings.ToLower(k), "x-forwarded-") {
+			delete(imr.r.Metadata, k)
+		}
+	}
+	return imr
+}
+
+// WithPageRequest sets the page and size query parameters. The request is left
+// unchanged unless both are positive.
+func (imr *InvokeMethodRequest) WithPageRequest(page, size int) *InvokeMethodRequest {
+	if page <= 0 || size <= 0 {
+		return imr
+	}
+	if imr.r.Message.HttpExtension == nil {
+		imr.WithHTTPExtension("", "")
+	}
+	if imr.r.Message.HttpExtension.Querystring == nil {
+		imr.r.Message.HttpExtension.Querystring = map[string]string{}
+	}
+	imr.r.Message.HttpExtension.Querystring["page"] = strconv.Itoa(page)
+	imr.r.Message.HttpExtension.Querystring["size"] = strconv.Itoa(size)
+	return imr
+}
+
+// PageRequest returns the page and size query parameters, and false if either is absent
+// or is not a positive integer
+func (imr *InvokeMethodRequest) PageRequest() (page, size int, ok bool) {
+	qs := imr.r.GetMessage().GetHttpExtension().GetQuerystring()
+	page, err := strconv.Atoi(qs["page"])
+	if err != nil || page <= 0 {
+		return 0, 0, false
+	}
+	size, err = strconv.Atoi(qs["size"])
+	if err != nil || size <= 0 {
+		return 0, 0, false
+	}
+	return page, size, true
+}
+
+// TextMapCarrier is the opentracing carrier interface accepted by both Inject and
+// Extract with the opentracing.TextMap format.
+type TextMapCarrier interface {
+	opentracing.TextMapWriter
+	opentracing.TextMapReader
+}
+
+// metadataCarrier is an opentracing text map carrier backed by the metadata of a request.
+type metadataCarrier struct {
+	imr *InvokeMethodRequest
+}
+
+// Set replaces the values of the metadata key with val.
+func (c metadataCarrier) Set(key, val string) {
+	c.imr.setMetadataValue(key, val)
+}
+
+// ForeachKey calls handler with the lowercase key and each value of the metadata,
+// stopping at the first error.
+func (c metadataCarrier) ForeachKey(handler func(key, val string) error) error {
+	for k, listVal := range c.imr.r.GetMetadata() {
+		for _, v := range listVal.GetValues() {
+			if err := handler(strings.ToLower(k), v); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// OpenTracingCarrier returns a carrier for Inject and Extract that reads and writes
+// the request metadata directly.
+func (imr *InvokeMethodRequest) OpenTracingCarrier() TextMapCarrier {
+	return metadataCarrier{imr: imr}
+}
+
+// IfMatchWeak returns the entity tags of the if-match header without their quotes and
+// weakness prefix, and whether any of them is a weak W/"..." tag. It returns false if
+// the header is absent.
+func (imr *InvokeMethodRequest) IfMatchWeak() (etags []string, weak bool, ok bool) {
+	values := imr.metadataValues(ifMatchHeader)
+	if len(values) == 0 {
+		return nil, false, false
+	}
+	for _, val := range values {
+		for _, etag := range strings.Split(val, ",") {
+			etag = strings.TrimSpace(etag)
+			if strings.HasPrefix(etag, "W/") {
+				weak = true
+				etag = etag[2:]
+			}
+			if etag = strings.Trim(etag, "\""); etag != "" {
+				etags = append(etags, etag)
+			}
+		}
+	}
+	return etags, weak, true
+}
+
+// WithCostUnits sets the quota units which the runtime debits for the request
+func (imr *InvokeMethodRequest) WithCostUnits(units int) *InvokeMethodRequest {
+	imr.setMetadataValue(costUnitsHeader, strconv.Itoa(units))
+	return imr
+}
+
+// CostUnits returns the quota units of the request, and false if they are unset or malformed
+func (imr *InvokeMethodRequest) CostUnits() (int, bool) {
+	val, ok := imr.metadataValue(costUnitsHeader)
+	if !ok {
+		return 0, false
+	}
+	units, err := strconv.Atoi(val)
+	if err != nil {
+		return 0, false
+	}
+	return units, true
+}
+
+// IsStreamingMethod returns true if the stream type header marks the target as a client,
+// server or bidi streaming gRPC method
+func (imr *InvokeMethodRequest) IsStreamingMethod() bool {
+	val, _ := imr.metadataValue(streamTypeHeader)
+	switch strings.ToLower(strings.TrimSpace(val)) {
+	case "client", "server", "bidi":
+		return true
+	}
+	return false
+}
+
+// ToWorkItem returns the descriptor of the request to queue for asynchronous processing,
+// which FromWorkItem turns back into a request
+func (imr *InvokeMethodRequest) ToWorkItem() (*WorkItem, error) {
+	m := imr.r.GetMessage()
+	if m.GetMethod() == "" {
+		return nil, errors.New("request has no method")
+	}
+
+	item := &WorkItem{
+		Method:      m.GetMethod(),
+		Querystring: imr.EncodeHTTPQueryString(),
+		ActorType:   imr.r.GetActor().GetActorType(),
+		ActorID:     imr.r.GetActor().GetActorId(),
+		EnqueueTime: time.Now().UTC(),
+	}
+	if m.GetHttpExtension() != nil {
+		item.Verb = m.GetHttpExtension().GetVerb().String()
+	}
+	if m.GetData() != nil {
+		item.ContentType, item.Data = imr.RawData()
+	}
+	if md := imr.r.GetMetadata(); len(md) > 0 {
+		item.Metadata = make(map[string][]string, len(md))
+		for k, listVal := range md {
+			item.Metadata[k] = append([]string(nil), listVal.GetValues()...)
+		}
+	}
+	return item, nil
+}
+
+// WithActorPartition sets the placement partition hint of the actor. A negative partition
+// is rejected and leaves the request unchanged.
+func (imr *InvokeMethodRequest) WithActorPartition(partition int) *InvokeMethodRequest {
+	if partition < 0 {
+		return imr
+	}
+	imr.setMetadataValue(actorPartitionHeader, strconv.Itoa(partition))
+	return imr
+}
+
+// ActorPartition returns the placement partition hint, and false if it is unset or is
+// not a non-negative integer
+func (imr *InvokeMethodRequest) ActorPartition() (int, bool) {
+	val, ok := imr.metadataValue(actorPartitionHeader)
+	if !ok {
+		return 0, false
+	}
+	partition, err := strconv.Atoi(val)
+	if err != nil || partition < 0 {
+		return 0, false
+	}
+	return partition, true
+}
+
+// VaryHeaders returns the sorted lowercase header names of the vary header without
+// duplicates. If the list has *, which means the response varies on more than the
+// headers, only * is returned.
+func (imr *InvokeMethodRequest) VaryHeaders() []string {
+	seen := map[string]bool{}
+	var headers []string
+	for _, val := range imr.metadataValues(varyHeader) {
+		for _, name := range strings.Split(val, ",") {
+			name = strings.ToLower(strings.TrimSpace(name))
+			if name == "*" {
+				return []string{"*"}
+			}
+			if name != "" && !seen[name] {
+				seen[name] = true
+				headers = append(headers, name)
+			}
+		}
+	}
+	sort.Strings(headers)
+	return headers
+}
+
+// VaryAwareCacheKey returns a hex SHA-256 response cache key of the verb, method,
+// querystring and the values of the request headers listed in vary, as returned by
+// VaryHeaders. It returns an empty string if vary has *, as such responses can't be cached.
+func (imr *InvokeMethodRequest) VaryAwareCacheKey(vary []string) string {
+	names := make([]string, 0, len(vary))
+	for _, name := range vary {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "*" {
+			return ""
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	m := imr.r.GetMessage()
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\n%s\n%s\n", m.GetHttpExtension().GetVerb(), m.GetMethod(), imr.EncodeHTTPQueryString())
+	for _, name := range names {
+		fmt.Fprintf(h, "%s:%s\n", name, strings.Join(imr.metadataValues(name), ","))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// LocalizationContext returns the preferred language of accept-language and the time
+// zone of the timezone header. A missing or invalid language is language.Und and a
+// missing or invalid time zone is nil. It returns false if neither is set.
+func (imr *InvokeMethodRequest) LocalizationContext() (lang language.Tag, tz *time.Location, ok bool) {
+	lang = language.Und
+	if val, found := imr.metadataValue("accept-language"); found {
+		if tags, _, err := language.ParseAcceptLanguage(val); err == nil && len(tags) > 0 {
+			lang = tags[0]
+			ok = true
+		}
+	}
+	if loc, found := imr.TimeZone(); found {
+		tz = loc
+		ok = true
+	}
+	return lang, tz, ok
+}
+
+// ValidateJSONDepth streams the JSON body and returns an error if its objects and arrays
+// are nested deeper than maxDepth. Non-JSON bodies are not checked.
+func (imr *InvokeMethodRequest) ValidateJSONDepth(maxDepth int) error {
+	contentType, data := imr.RawData()
+	if !IsJSONContentType(contentType) || len(data) == 0 {
+		return nil
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	depth := 0
+	for {
+		token, err := dec.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return errors.Wrap(err, "invalid JSON body")
+		}
+		switch token {
+		case json.Delim('{'), json.Delim('['):
+			depth++
+			if depth > maxDepth {
+				return errors.Errorf("JSON body is nested deeper than %d levels", maxDepth)
+			}
+		case json.Delim('}'), json.Delim(']'):
+			depth--
+		}
+	}
+}
+
+// WithExperiment assigns the variant of the A/B test name to the request, keeping the
+// assignments of the other tests
+func (imr *InvokeMethodRequest) WithExperiment(name, variant string) *InvokeMethodRequest {
+	val, _ := imr.metadataValue(experimentsHeader)
+	experiments := decodeHeaderMap(val)
+	experiments[name] = variant
+	imr.setMetadataValue(experimentsHeader, encodeHeaderMap(experiments))
+	return imr
+}
+
+// Experiment returns the variant of the A/B test name, and false if the request is not
+// assigned to the test
+func (imr *InvokeMethodRequest) Experiment(name string) (string, bool) {
+	val, _ := imr.metadataValue(experimentsHeader)
+	variant, ok := decodeHeaderMap(val)[name]
+	return variant, ok
+}
+
+// SameActorAs returns true if both requests target the same actor type and id
+func (imr *InvokeMethodRequest) SameActorAs(other *InvokeMethodRequest) bool {
+	if other == nil {
+		return false
+	}
+	a, o := imr.r.GetActor(), other.r.GetActor()
+	if a == nil || o == nil {
+		return false
+	}
+	return a.GetActorType() == o.GetActorType() && a.GetActorId() == o.GetActorId()
+}
+
+// SetTraceStateEntry sets the tracestate entry of vendor, keeping the other entries. As
+// required by W3C trace context, the entry is moved to the front of the list and the
+// oldest entries at the end of the list are evicted beyond 32 entries. Invalid vendor
+// keys or values leave the request unchanged.
+func (imr *InvokeMethodRequest) SetTraceStateEntry(vendor, value string) *InvokeMethodRequest {
+	if !traceStateKeyRegex.MatchString(vendor) || value == "" || strings.ContainsAny(value, ",=") {
+		return imr
+	}
+
+	entries := []string{vendor + "=" + value}
+	for _, val := range imr.metadataValues(tracestateHeader) {
+		for _, entry := range strings.Split(val, ",") {
+			entry = strings.TrimSpace(entry)
+			if entry == "" || strings.SplitN(entry, "=", 2)[0] == vendor {
+				continue
+			}
+			entries = append(entries, entry)
+		}
+	}
+	if len(entries) > maxTraceStateEntries {
+		entries = entries[:maxTraceStateEntries]
+	}
+	imr.setMetadataValue(tracestateHeader, strings.Join(entries, ","))
+	return imr
+}
+
+// IsBulkActorStateRequest returns true for calls to actors/{type}/{id}/state/bulk with a
+// JSON array of state keys as body
+func (imr *InvokeMethodRequest) IsBulkActorStateRequest() bool {
+	segments := strings.Split(strings.Trim(imr.r.GetMessage().GetMethod(), "/"), "/")
+	if len(segments) != 5 || segments[0] != "actors" || segments[1] == "" || segments[2] == "" ||
+		segments[3] != "state" || segments[4] != "bulk" {
+		return false
+	}
+	_, data := imr.RawData()
+	var keys []string
+	return json.Unmarshal(data, &keys) == nil && keys != nil
+}
+
+// WithClientSDK sets the name and version of the client SDK which sent the request
+func (imr *InvokeMethodRequest) WithClientSDK(name, version string) *InvokeMethodRequest {
+	imr.setMetadataValue(clientSDKHeader, name+"/"+version)
+	return imr
+}
+
+// ClientSDK returns the name and version of the client SDK, and false if it is unset or malformed
+func (imr *InvokeMethodRequest) ClientSDK() (name, version string, ok bool) {
+	val, found := imr.metadataValue(clientSDKHeader)
+	if !found {
+		return "", "", false
+	}
+	parts := strings.SplitN(val, "/", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return "", "", false
+	}
+	return parts[0], parts[1], true
+}
+
+// BearerAudience returns the aud claim of the bearer token of the authorization header.
+// The token signature is not verified, so the audience must only be used for routing.
+func (imr *InvokeMethodRequest) BearerAudience() ([]string, error) {
+	var token string
+	for _, val := range imr.AuthorizationValues() {
+		parts := strings.SplitN(strings.TrimSpace(val), " ", 2)
+		if len(parts) == 2 && strings.EqualFold(parts[0], "bearer") {
+			token = strings.TrimSpace(parts[1])
+			break
+		}
+	}
+	if token == "" {
+		return nil, errors.New("request has no bearer token")
+	}
+	segments, ok := jwtSegments(token)
+	if !ok {
+		return nil, errors.New("bearer token is not a JWT")
+	}
+
+	var claims struct {
+		Aud json.RawMessage `json:"aud"`
+	}
+	if err := json.Unmarshal(segments[1], &claims); err != nil {
+		return nil, errors.Wrap(err, "invalid bearer token payload")
+	}
+	if len(claims.Aud) == 0 {
+		return nil, errors.New("bearer token has no aud claim")
+	}
+	var aud string
+	if err := json.Unmarshal(claims.Aud, &aud); err == nil {
+		return []string{aud}, nil
+	}
+	var audiences []string
+	if err := json.Unmarshal(claims.Aud, &audiences); err != nil {
+		return nil, errors.Wrap(err, "invalid aud claim")
+	}
+	return audiences, nil
+}
+
+// WithTracingDisabled makes the observability layer skip span creation for the request
+func (imr *InvokeMethodRequest) WithTracingDisabled() *InvokeMethodRequest {
+	imr.setMetadataValue(tracingDisabledHeader, "true")
+	return imr
+}
+
+// TracingDisabled returns true if no spans must be created for the request
+func (imr *InvokeMethodRequest) TracingDisabled() bool {
+	val, _ := imr.metadataValue(tracingDisabledHeader)
+	disabled, _ := strconv.ParseBool(val)
+	return disabled
+}
+
+// HeaderEcho returns a JSON object with the values of the metadata keyed by lowercase
+// header name, leaving out the sensitive headers. Contract tests use it to assert the
+// headers a service receives.
+func (imr *InvokeMethodRequest) HeaderEcho() []byte {
+	echo, _ := json.Marshal(imr.echoHeaders())
+	return echo
+}
+
+// WithSLO sets the service level objective which buckets the latency metrics of the request
+func (imr *InvokeMethodRequest) WithSLO(name string) *InvokeMethodRequest {
+	imr.setMetadataValue(sloHeader, name)
+	return imr
+}
+
+// SLO returns the service level objective of the request, and false if it is unset
+func (imr *InvokeMethodRequest) SLO() (string, bool) {
+	return imr.metadataValue(sloHeader)
+}
+
+// ValidateQueryValueLengths returns an error if a querystring value is longer than max bytes
+func (imr *InvokeMethodRequest) ValidateQueryValueLengths(max int) error {
+	for k, v := range imr.r.GetMessage().GetHttpExtension().GetQuerystring() {
+		if len(v) > max {
+			return errors.Errorf("query parameter %s is %d bytes long, exceeding the %d bytes limit", k, len(v), max)
+		}
+	}
+	return nil
+}
+
+// WithCompensationPayload sets the payload which rolls back the saga step, separately
+// from the body of the request
+func (imr *InvokeMethodRequest) WithCompensationPayload(data []byte, contentType string) *InvokeMethodRequest {
+	imr.setMetadataValue(compensationPayloadHeader, base64.StdEncoding.EncodeToString(data))
+	imr.setMetadataValue(compensationContentTypeHeader, contentType)
+	return imr
+}
+
+// CompensationPayload returns the rollback payload of the saga step and its content type,
+// and false if it is unset or malformed
+func (imr *InvokeMethodRequest) CompensationPayload() (data []byte, contentType string, ok bool) {
+	val, found := imr.metadataValue(compensationPayloadHeader)
+	if !found {
+		return nil, "", false
+	}
+	data, err := base64.StdEncoding.DecodeString(val)
+	if err != nil {
+		return nil, "", false
+	}
+	contentType, _ = imr.metadataValue(compensationContentTypeHeader)
+	return data, contentType, true
+}
+
+// StripProxyAuth removes the proxy-authorization header, which is meant for the proxy
+// and not the app
+func (imr *InvokeMethodRequest) StripProxyAuth() *InvokeMethodRequest {
+	imr.deleteMetadata(proxyAuthorizationHeader)
+	return imr
+}
+
+// WithDataClassification sets the data classification label of the request, one of public,
+// internal, confidential and restricted. Unknown labels leave the request unchanged.
+func (imr *InvokeMethodRequest) WithDataClassification(label string) *InvokeMethodRequest {
+	label = strings.ToLower(label)
+	if !dataClassifications[label] {
+		return imr
+	}
+	imr.setMetadataValue(dataClassificationHeader, label)
+	return imr
+}
+
+// DataClassification returns the data classification label, and false if it is unset or unknown
+func (imr *InvokeMethodRequest) DataClassification() (string, bool) {
+	label, _ := imr.metadataValue(dataClassificationHeader)
+	if !dataClassifications[label] {
+		return "", false
+	}
+	return label, true
+}
+
+// echoHeaders returns the values of the metadata keyed by lowercase header name, leaving
+// out the sensitive headers. The values of keys differing only in case are merged in the
+// order of the sorted keys so that the echo is deterministic.
+func (imr *InvokeMethodRequest) echoHeaders() map[string][]string {
+	md := imr.r.GetMetadata()
+	keys := make([]string, 0, len(md))
+	for k := range md {
+		if !isSensitiveHeader(k) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	headers := map[string][]string{}
+	for _, k := range keys {
+		key := strings.ToLower(k)
+		headers[key] = append(headers[key], md[k].GetValues()...)
+	}
+	return headers
+}
+
+// WithRoute sets the subscription route of the app as the method of the request. An
+// empty route leaves the method unchanged.
+func (imr *InvokeMethodRequest) WithRoute(route string) *InvokeMethodRequest {
+	if route != "" {
+		imr.r.Message.Method = route
+	}
+	return imr
+}
+
+// metadataValues returns all values of the metadata key, matching key case-insensitively
+func (imr *InvokeMethodRequest) metadataValues(key string) []string {
+	var values []string
+	for k, listVal := range imr.r.GetMetadata() {
+		if strings.EqualFold(k, key) {
+			values = append(values, listVal.GetValues()...)
+		}
+	}
+	return values
+}
+
+// metadataValue returns the first value of the metadata key, matching key case-insensitively
+func (imr *InvokeMethodRequest) metadataValue(key string) (string, bool) {
+	values := imr.metadataValues(key)
+	if len(values) == 0 {
+		return "", false
+	}
+	return values[0], true
+}
+
+// setMetadataValue replaces all values of the metadata key with the given value
+func (imr *InvokeMethodRequest) setMetadataValue(key, value string) {
+	imr.deleteMetadata(key)
+	if imr.r.Metadata == nil {
+		imr.r.Metadata = DaprInternalMetadata{}
+	}
+	imr.r.Metadata[strings.ToLower(key)] = &internalv1pb.ListStringValue{Values: []string{value}}
+}
+
+// deleteMetadata removes the metadata key, matching key case-insensitively
+func (imr *InvokeMethodRequest) deleteMetadata(key string) {
+	for k := range imr.r.GetMetadata() {
+		if strings.EqualFold(k, key) {
+			delete(imr.r.Metadata, k)
+		}
+	}
+}
+
+// expiry returns the expiry time of the message, and false if it is unset or malformed
+func (imr *InvokeMethodRequest) expiry() (time.Time, bool) {
+	val, ok := imr.metadataValue(expiryHeader)
+	if !ok {
+		return time.Time{}, false
+	}
+	expiry, err := time.Parse(time.RFC3339Nano, val)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return expiry, true
+}
+
+// sigV4Escape URI-encodes s as required by AWS SigV4, leaving only the unreserved
+// characters unescaped
+func sigV4Escape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if ('A' <= c && c <= 'Z') || ('a' <= c && c <= 'z') || ('0' <= c && c <= '9') ||
+			c == '-' || c == '_' || c == '.' || c == '~' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// queryValueString formats a scalar struct field value for the querystring
+func queryValueString(v reflect.Value) (string, error) {
+	switch v.Kind() {
+	case reflect.String:
+		return v.String(), nil
+	case reflect.Bool:
+		return strconv.FormatBool(v.Bool()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(v.Uint(), 10), nil
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(v.Float(), 'f', -1, 64), nil
+	}
+	return "", errors.Errorf("unsupported kind %s", v.Kind())
+}
+
+// hashBucket maps s into a stable bucket in [0, buckets) using FNV-1a
+func hashBucket(s string, buckets int) int {
+	h := fnv.New32a()
+	h.Write([]byte(s))
+	return int(h.Sum32() % uint32(buckets))
+}
+
+// jwtSegments decodes the three base64url encoded segments of a compact JWT
+func jwtSegments(token string) ([][]byte, bool) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, false
+	}
+	segments := make([][]byte, 0, len(parts))
+	for _, part := range parts {
+		if part == "" {
+			return nil, false
+		}
+		decoded, err := base64.RawURLEncoding.DecodeString(part)
+		if err != nil {
+			return nil, false
+		}
+		segments = append(segments, decoded)
+	}
+	return segments, true
+}
+
+// isTextualContentType returns true if the media type carries text
+func isTextualContentType(contentType string) bool {
+	mediaType := strings.ToLower(strings.TrimSpace(strings.SplitN(contentType, ";", 2)[0]))
+	switch {
+	case strings.HasPrefix(mediaType, "text/"),
+		strings.HasSuffix(mediaType, "/json"), strings.HasSuffix(mediaType, "+json"),
+		strings.HasSuffix(mediaType, "/xml"), strings.HasSuffix(mediaType, "+xml"),
+		strings.HasSuffix(mediaType, "/yaml"), strings.HasSuffix(mediaType, "/javascript"),
+		mediaType == "application/x-www-form-urlencoded":
+		return true
+	}
+	return false
+}
+
+// encodeHeaderMap encodes m into a single header value as sorted url encoded pairs
+func encodeHeaderMap(m map[string]string) string {
+	values := url.Values{}
+	for k, v := range m {
+		values.Set(k, v)
+	}
+	return values.Encode()
+}
+
+// decodeHeaderMap decodes a header value encoded by encodeHeaderMap
+func decodeHeaderMap(val string) map[string]string {
+	m := map[string]string{}
+	values, _ := url.ParseQuery(val)
+	for k, v := range values {
+		m[k] = v[0]
+	}
+	return m
+}
+
+// isSensitiveHeader returns true if the header carries credentials
+func isSensitiveHeader(key string) bool {
+	return sensitiveHeaders[strings.ToLower(key)]
+}
+
+// charsetEncoding returns the encoding of the MIME charset name
+func charsetEncoding(name string) (encoding.Encoding, error) {
+	enc, err := ianaindex.MIME.Encoding(name)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unknown charset %s", name)
+	}
+	if enc == nil {
+		return nil, errors.Errorf("unsupported charset %s", name)
+	}
+	return enc, nil
+}
+
+// parseWarnings parses the comma separated warning-values of a Warning header value
+func parseWarnings(val string) []WarningHeader {
+	var warnings []WarningHeader
+	i := 0
+	for {
+		for i < len(val) && (val[i] == ' ' || val[i] == ',') {
+			i++
+		}
+		if i >= len(val) {
+			return warnings
+		}
+
+		fields := strings.SplitN(val[i:], " ", 3)
+		if len(fields) != 3 {
+			return warnings
+		}
+		code, err := strconv.Atoi(fields[0])
+		if err != nil || len(fields[0]) != 3 {
+			return warnings
+		}
+		warning := WarningHeader{Code: code, Agent: fields[1]}
+		i += len(fields[0]) + len(fields[1]) + 2
+
+		var ok bool
+		if warning.Text, i, ok = parseQuotedString(val, i); !ok {
+			return warnings
+		}
+		if i+1 < len(val) && val[i] == ' ' && val[i+1] == '"' {
+			var date string
+			if date, i, ok = parseQuotedString(val, i+1); !ok {
+				return warnings
+			}
+			if warning.Date, err = http.ParseTime(date); err != nil {
+				return warnings
+			}
+		}
+		warnings = append(warnings, warning)
+	}
+}
+
+// parseQuotedString parses the quoted-string starting at s[i] and returns its unescaped
+// value and the index following its closing quote
+func parseQuotedString(s string, i int) (string, int, bool) {
+	if i >= len(s) || s[i] != '"' {
+		return "", i, false
+	}
+	var b strings.Builder
+	for i++; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+			if i < len(s) {
+				b.WriteByte(s[i])
+			}
+		case '"':
+			return b.String(), i + 1, true
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return "", i, false
+}
+
+// splitOutsideQuotes splits s at the sep bytes which are not inside a quoted-string
+func splitOutsideQuotes(s string, sep byte) []string {
+	var parts []string
+	start, quoted := 0, false
+	for i := 0; i < len(s); i++ {
+		switch {
+		case quoted && s[i] == '\\':
+			i++
+		case s[i] == '"':
+			quoted = !quoted
+		case !quoted && s[i] == sep:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// parseQualityList parses the comma separated values of a header with q-values, such as
+// accept-charset, and returns them ordered by decreasing q-value. Values with the same
+// q-value keep their order and values with q=0 are left out.
+func parseQualityList(headerValues []string) []string {
+	type weighted struct {
+		value string
+		q     float64
+	}
+	var list []weighted
+	for _, val := range headerValues {
+		for _, elem := range strings.Split(val, ",") {
+			parts := strings.Split(elem, ";")
+			value := strings.TrimSpace(parts[0])
+			if value == "" {
+				continue
+			}
+			q := 1.0
+			for _, param := range parts[1:] {
+				kv := strings.SplitN(strings.TrimSpace(param), "=", 2)
+				if len(kv) == 2 && strings.EqualFold(kv[0], "q") {
+					if parsed, err := strconv.ParseFloat(strings.TrimSpace(kv[1]), 64); err == nil {
+						q = parsed
+					}
+				}
+			}
+			if q > 0 {
+				list = append(list, weighted{value: value, q: q})
+			}
+		}
+	}
+
+	sort.SliceStable(list, func(i, j int) bool {
+		return list[i].q > list[j].q
+	})
+	values := make([]string, len(list))
+	for i, w := range list {
+		values[i] = w.value
+	}
+	return values
+}
+
+// convertJSONNumbers replaces the json.Number values of v decoded with UseNumber by int64
+// when they are integers and by float64 otherwise
+func convertJSONNumbers(v interface{}) interface{} {
+	switch val := v.(type) {
+	case json.Number:
+		if i, err := val.Int64(); err == nil {
+			return i
+		}
+		f, _ := val.Float64()
+		return f
+	case map[string]interface{}:
+		for k, item := range val {
+			val[k] = convertJSONNumbers(item)
+		}
+	case []interface{}:
+		for i, item := range val {
+			val[i] = convertJSONNumbers(item)
+		}
+	}
+	return v
+}
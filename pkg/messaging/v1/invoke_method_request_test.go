@@ -6,13 +6,32 @@
 package v1
 
 import (
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/url"
+	"strings"
 	"testing"
+	"time"
 
 	commonv1pb "github.com/dapr/dapr/pkg/proto/common/v1"
 	internalv1pb "github.com/dapr/dapr/pkg/proto/internals/v1"
 	"github.com/golang/protobuf/ptypes/any"
+	"github.com/google/uuid"
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/mocktracer"
 	"github.com/stretchr/testify/assert"
 	"github.com/valyala/fasthttp"
+	"github.com/vmihailenco/msgpack/v4"
+	"go.opencensus.io/trace"
+	"golang.org/x/text/language"
+	"google.golang.org/grpc/codes"
 )
 
 func TestInvokeRequest(t *testing.T) {
@@ -150,3 +169,3141 @@ func TestProto(t *testing.T) {
 	assert.Equal(t, "application/json", req2.GetMessage().ContentType)
 	assert.Equal(t, []byte("test"), req2.GetMessage().Data.Value)
 }
+
+func TestForceSampled(t *testing.T) {
+	t.Run("unsampled traceparent becomes sampled", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method")
+		req.WithMetadata(map[string][]string{
+			"traceparent": {"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00"},
+		})
+		req.ForceSampled()
+		assert.Equal(t, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", req.Metadata()["traceparent"].GetValues()[0])
+	})
+
+	t.Run("missing traceparent", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method")
+		req.ForceSampled()
+		_, ok := req.Metadata()["traceparent"]
+		assert.False(t, ok)
+	})
+}
+
+func TestActorKey(t *testing.T) {
+	t.Run("full actor", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method").WithActor("testActor", "1")
+		key, ok := req.ActorKey()
+		assert.True(t, ok)
+		assert.Equal(t, "testActor/1", key)
+	})
+
+	t.Run("partial actor", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method").WithActor("testActor", "")
+		key, ok := req.ActorKey()
+		assert.False(t, ok)
+		assert.Equal(t, "", key)
+	})
+
+	t.Run("nil actor", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method")
+		_, ok := req.ActorKey()
+		assert.False(t, ok)
+	})
+}
+
+func TestEchoResponse(t *testing.T) {
+	req := NewInvokeMethodRequest("echo_method")
+	req.WithHTTPExtension("GET", "")
+	req.WithMetadata(map[string][]string{"x-sample": {"sample-value"}})
+
+	contentType, body := req.EchoResponse()
+	assert.Equal(t, JSONContentType, contentType)
+
+	var echo map[string]interface{}
+	assert.NoError(t, json.Unmarshal(body, &echo))
+	assert.Equal(t, "echo_method", echo["method"])
+	assert.Equal(t, "GET", echo["verb"])
+	assert.Equal(t, []interface{}{"sample-value"}, echo["headers"].(map[string]interface{})["x-sample"])
+
+	t.Run("sensitive headers are not echoed", func(t *testing.T) {
+		req := NewInvokeMethodRequest("echo_method")
+		req.WithMetadata(map[string][]string{
+			"authorization":  {"Bearer secret"},
+			"cookie":         {"session=secret"},
+			"dapr-api-token": {"secret"},
+			"x-sample":       {"sample-value"},
+		})
+
+		_, body := req.EchoResponse()
+		assert.NotContains(t, string(body), "secret")
+		var echo struct {
+			Headers map[string][]string `json:"headers"`
+		}
+		assert.NoError(t, json.Unmarshal(body, &echo))
+		assert.Equal(t, map[string][]string{"x-sample": {"sample-value"}}, echo.Headers)
+	})
+}
+
+func TestRetryBudget(t *testing.T) {
+	t.Run("set and get", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method").WithRetryBudget(2.5)
+		tokens, ok := req.RetryBudget()
+		assert.True(t, ok)
+		assert.Equal(t, 2.5, tokens)
+	})
+
+	t.Run("absent", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method")
+		_, ok := req.RetryBudget()
+		assert.False(t, ok)
+	})
+
+	t.Run("malformed", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method")
+		req.WithMetadata(map[string][]string{"dapr-retry-budget": {"lots"}})
+		_, ok := req.RetryBudget()
+		assert.False(t, ok)
+	})
+}
+
+func TestNormalizeHead(t *testing.T) {
+	t.Run("HEAD with body", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method")
+		req.WithHTTPExtension("HEAD", "")
+		req.WithRawData([]byte("test"), "text/plain")
+		req.NormalizeHead()
+
+		_, data := req.RawData()
+		assert.Nil(t, data)
+		assert.Equal(t, "0", req.Metadata()["content-length"].GetValues()[0])
+	})
+
+	t.Run("GET is untouched", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method")
+		req.WithHTTPExtension("GET", "")
+		req.WithRawData([]byte("test"), "text/plain")
+		req.NormalizeHead()
+
+		_, data := req.RawData()
+		assert.Equal(t, []byte("test"), data)
+		_, ok := req.Metadata()["content-length"]
+		assert.False(t, ok)
+	})
+}
+
+func TestAuthorizationValues(t *testing.T) {
+	t.Run("one authorization header", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method")
+		req.WithMetadata(map[string][]string{"authorization": {"Bearer token1"}})
+		assert.Equal(t, []string{"Bearer token1"}, req.AuthorizationValues())
+	})
+
+	t.Run("two authorization headers", func(t *testing.T) {
+		var fastReq = fasthttp.AcquireRequest()
+		fastReq.Header.Add("Authorization", "Bearer token1")
+		fastReq.Header.Add("Authorization", "Basic dXNlcjpwYXNz")
+
+		req := NewInvokeMethodRequest("test_method")
+		req.WithFastHTTPHeaders(&fastReq.Header)
+		assert.Equal(t, []string{"Bearer token1", "Basic dXNlcjpwYXNz"}, req.AuthorizationValues())
+	})
+
+	t.Run("no authorization header", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method")
+		assert.Empty(t, req.AuthorizationValues())
+	})
+}
+
+func TestContentTypeRaw(t *testing.T) {
+	req := NewInvokeMethodRequest("test_method")
+	req.r.Message.ContentType = "application/xml"
+
+	contentType, _ := req.RawData()
+	assert.Equal(t, "", contentType)
+	assert.Equal(t, "application/xml", req.ContentTypeRaw())
+}
+
+func TestForwarded(t *testing.T) {
+	t.Run("parse two elements", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method")
+		req.WithMetadata(map[string][]string{
+			"Forwarded": {`for=192.0.2.60;proto=http;by=203.0.113.43, for="[2001:db8:cafe::17]:4711";host=example.com`},
+		})
+
+		elements := req.Forwarded()
+		assert.Equal(t, []ForwardedElement{
+			{For: "192.0.2.60", Proto: "http", By: "203.0.113.43"},
+			{For: "[2001:db8:cafe::17]:4711", Host: "example.com"},
+		}, elements)
+	})
+
+	t.Run("append elements", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method")
+		req.AppendForwarded(ForwardedElement{For: "192.0.2.60", Proto: "https"})
+		req.AppendForwarded(ForwardedElement{For: "[2001:db8:cafe::17]:4711"})
+
+		assert.Equal(t, `for=192.0.2.60;proto=https, for="[2001:db8:cafe::17]:4711"`, req.Metadata()["forwarded"].GetValues()[0])
+		assert.Len(t, req.Forwarded(), 2)
+	})
+
+	t.Run("round trip quoted separators", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method")
+		req.AppendForwarded(ForwardedElement{For: "a,b;c", Proto: "https"})
+		req.AppendForwarded(ForwardedElement{For: `say "hi"`})
+
+		assert.Equal(t, []ForwardedElement{
+			{For: "a,b;c", Proto: "https"},
+			{For: `say "hi"`},
+		}, req.Forwarded())
+	})
+
+	t.Run("absent header", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method")
+		assert.Empty(t, req.Forwarded())
+	})
+}
+
+func TestCoalescable(t *testing.T) {
+	newReq := func(verb, qs, accept string) *InvokeMethodRequest {
+		req := NewInvokeMethodRequest("orders")
+		req.WithHTTPExtension(verb, qs)
+		req.WithMetadata(map[string][]string{"accept": {accept}, "x-request-id": {qs}})
+		return req
+	}
+
+	t.Run("coalescable", func(t *testing.T) {
+		assert.True(t, newReq("GET", "id=1", "application/json").Coalescable(newReq("GET", "id=1", "application/json")))
+	})
+
+	t.Run("different querystring", func(t *testing.T) {
+		assert.False(t, newReq("GET", "id=1", "application/json").Coalescable(newReq("GET", "id=2", "application/json")))
+	})
+
+	t.Run("different accept header", func(t *testing.T) {
+		assert.False(t, newReq("GET", "id=1", "application/json").Coalescable(newReq("GET", "id=1", "text/plain")))
+	})
+
+	t.Run("not GET", func(t *testing.T) {
+		assert.False(t, newReq("POST", "id=1", "application/json").Coalescable(newReq("POST", "id=1", "application/json")))
+	})
+
+	t.Run("different actors", func(t *testing.T) {
+		req := newReq("GET", "id=1", "application/json").WithActor("cart", "1")
+		assert.True(t, req.Coalescable(newReq("GET", "id=1", "application/json").WithActor("cart", "1")))
+		assert.False(t, req.Coalescable(newReq("GET", "id=1", "application/json").WithActor("cart", "2")))
+		assert.False(t, req.Coalescable(newReq("GET", "id=1", "application/json").WithActor("order", "1")))
+		assert.False(t, req.Coalescable(newReq("GET", "id=1", "application/json")))
+	})
+}
+
+func TestErrorHint(t *testing.T) {
+	t.Run("set and get", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method").WithErrorHint("UNAVAILABLE", "upstream timed out")
+		code, reason, ok := req.ErrorHint()
+		assert.True(t, ok)
+		assert.Equal(t, "UNAVAILABLE", code)
+		assert.Equal(t, "upstream timed out", reason)
+	})
+
+	t.Run("absent", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method")
+		_, _, ok := req.ErrorHint()
+		assert.False(t, ok)
+	})
+}
+
+func TestIsGRPCWeb(t *testing.T) {
+	testCases := []struct {
+		contentType string
+		expected    bool
+	}{
+		{"application/grpc-web", true},
+		{"application/grpc-web+proto", true},
+		{"application/grpc-web-text", true},
+		{"application/grpc-web-text+proto", true},
+		{"application/grpc", false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.contentType, func(t *testing.T) {
+			req := NewInvokeMethodRequest("test_method")
+			req.WithRawData([]byte("test"), tc.contentType)
+			assert.Equal(t, tc.expected, req.IsGRPCWeb())
+		})
+	}
+}
+
+func TestUnframeGRPCWebText(t *testing.T) {
+	// data frame: flag 0x00, 4 bytes length, message
+	frame := []byte{0x00, 0x00, 0x00, 0x00, 0x03, 0x0a, 0x01, 0x61}
+
+	t.Run("framed base64 body", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method")
+		req.WithRawData([]byte(base64.StdEncoding.EncodeToString(frame)), "application/grpc-web-text+proto")
+
+		assert.NoError(t, req.UnframeGRPCWebText())
+		contentType, data := req.RawData()
+		assert.Equal(t, "application/grpc-web+proto", contentType)
+		assert.Equal(t, frame, data)
+	})
+
+	t.Run("invalid base64 body", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method")
+		req.WithRawData([]byte("not base64!"), "application/grpc-web-text")
+		assert.Error(t, req.UnframeGRPCWebText())
+	})
+
+	t.Run("binary grpc-web body is untouched", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method")
+		req.WithRawData(frame, "application/grpc-web")
+
+		assert.NoError(t, req.UnframeGRPCWebText())
+		_, data := req.RawData()
+		assert.Equal(t, frame, data)
+	})
+}
+
+func TestQueryParamCount(t *testing.T) {
+	t.Run("no http extension", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method")
+		assert.Equal(t, 0, req.QueryParamCount())
+	})
+
+	t.Run("one param", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method")
+		req.WithHTTPExtension("GET", "query1=value1")
+		assert.Equal(t, 1, req.QueryParamCount())
+	})
+
+	t.Run("several params", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method")
+		req.WithHTTPExtension("GET", "query1=value1&query2=value2&query3=value3")
+		assert.Equal(t, 3, req.QueryParamCount())
+	})
+}
+
+func TestTruncateMetadataValues(t *testing.T) {
+	req := NewInvokeMethodRequest("test_method")
+	req.WithMetadata(map[string][]string{
+		"long":  {"0123456789abcdef"},
+		"short": {"0123"},
+	})
+	req.TruncateMetadataValues(10)
+
+	t.Run("value over the limit", func(t *testing.T) {
+		assert.Equal(t, "0123456...", req.Metadata()["long"].GetValues()[0])
+	})
+
+	t.Run("value under the limit", func(t *testing.T) {
+		assert.Equal(t, "0123", req.Metadata()["short"].GetValues()[0])
+	})
+
+	t.Run("negative limit", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method")
+		req.WithMetadata(map[string][]string{"long": {"0123456789abcdef"}})
+		assert.NotPanics(t, func() { req.TruncateMetadataValues(-1) })
+		assert.Equal(t, "", req.Metadata()["long"].GetValues()[0])
+	})
+}
+
+func TestGetMetadataInt(t *testing.T) {
+	req := NewInvokeMethodRequest("test_method")
+	req.WithMetadata(map[string][]string{
+		"x-max-items": {"25"},
+		"x-invalid":   {"many"},
+	})
+
+	t.Run("valid int", func(t *testing.T) {
+		assert.Equal(t, 25, req.GetMetadataInt("X-Max-Items", 10))
+	})
+
+	t.Run("invalid value", func(t *testing.T) {
+		assert.Equal(t, 10, req.GetMetadataInt("x-invalid", 10))
+	})
+
+	t.Run("absent", func(t *testing.T) {
+		assert.Equal(t, 10, req.GetMetadataInt("x-absent", 10))
+	})
+}
+
+func TestTimeZone(t *testing.T) {
+	t.Run("valid time zone", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method").WithTimeZone("UTC")
+		loc, ok := req.TimeZone()
+		assert.True(t, ok)
+		assert.Equal(t, time.UTC.String(), loc.String())
+	})
+
+	t.Run("invalid time zone", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method").WithTimeZone("Mars/Olympus_Mons")
+		_, ok := req.TimeZone()
+		assert.False(t, ok)
+	})
+
+	t.Run("absent", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method")
+		_, ok := req.TimeZone()
+		assert.False(t, ok)
+	})
+}
+
+func TestEffectiveVerbFor(t *testing.T) {
+	req := NewInvokeMethodRequest("test_method")
+	req.WithHTTPExtension("GET", "")
+
+	t.Run("http", func(t *testing.T) {
+		assert.Equal(t, "GET", req.EffectiveVerbFor("http"))
+	})
+
+	t.Run("grpc", func(t *testing.T) {
+		assert.Equal(t, "POST", req.EffectiveVerbFor("grpc"))
+	})
+
+	t.Run("no http extension", func(t *testing.T) {
+		assert.Equal(t, "POST", NewInvokeMethodRequest("test_method").EffectiveVerbFor("http"))
+	})
+}
+
+func TestOrigin(t *testing.T) {
+	t.Run("internal", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method").WithOrigin(OriginInternal)
+		assert.Equal(t, OriginInternal, req.Origin())
+	})
+
+	t.Run("unset is external", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method")
+		assert.Equal(t, OriginExternal, req.Origin())
+	})
+}
+
+func TestStripAuthForInternal(t *testing.T) {
+	t.Run("internal origin is stripped", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method")
+		req.WithMetadata(map[string][]string{"Authorization": {"Bearer token"}})
+		req.WithOrigin(OriginInternal).StripAuthForInternal()
+		assert.Empty(t, req.AuthorizationValues())
+	})
+
+	t.Run("external origin is kept", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method")
+		req.WithMetadata(map[string][]string{"Authorization": {"Bearer token"}})
+		req.WithOrigin(OriginExternal).StripAuthForInternal()
+		assert.Equal(t, []string{"Bearer token"}, req.AuthorizationValues())
+	})
+
+	t.Run("forged internal origin is kept", func(t *testing.T) {
+		var fastReq = fasthttp.AcquireRequest()
+		fastReq.Header.Add("Authorization", "Bearer token")
+		fastReq.Header.Add("Dapr-Origin", string(OriginInternal))
+
+		req := NewInvokeMethodRequest("test_method")
+		req.WithFastHTTPHeaders(&fastReq.Header).StripAuthForInternal()
+		assert.Equal(t, OriginExternal, req.Origin())
+		assert.Equal(t, []string{"Bearer token"}, req.AuthorizationValues())
+
+		req = NewInvokeMethodRequest("test_method")
+		req.WithMetadata(map[string][]string{"authorization": {"Bearer token"}, "dapr-origin": {string(OriginInternal)}})
+		req.StripTrustHeaders().StripAuthForInternal()
+		assert.Equal(t, []string{"Bearer token"}, req.AuthorizationValues())
+	})
+}
+
+func TestCacheControl(t *testing.T) {
+	t.Run("directives", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method")
+		req.WithMetadata(map[string][]string{"Cache-Control": {"no-cache, max-age=60"}})
+		assert.Equal(t, map[string]string{"no-cache": "", "max-age": "60"}, req.CacheControl())
+	})
+
+	t.Run("absent header", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method")
+		assert.Empty(t, req.CacheControl())
+	})
+}
+
+func TestNoTransform(t *testing.T) {
+	t.Run("present", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method")
+		req.WithMetadata(map[string][]string{"cache-control": {"max-age=60, no-transform"}})
+		assert.True(t, req.NoTransform())
+	})
+
+	t.Run("absent", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method")
+		req.WithMetadata(map[string][]string{"cache-control": {"no-cache"}})
+		assert.False(t, req.NoTransform())
+	})
+}
+
+func TestSynthetic(t *testing.T) {
+	t.Run("set and get", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method").WithSynthetic("uptime-probe")
+		source, ok := req.IsSynthetic()
+		assert.True(t, ok)
+		assert.Equal(t, "uptime-probe", source)
+	})
+
+	t.Run("absent", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method")
+		_, ok := req.IsSynthetic()
+		assert.False(t, ok)
+	})
+}
+
+func TestCanonicalRequest(t *testing.T) {
+	req := NewInvokeMethodRequest("orders/my order")
+	req.WithHTTPExtension("GET", "b=2&a=hello world")
+	req.WithMetadata(map[string][]string{
+		"Host":       {"example.com"},
+		"X-Amz-Date": {"20150830T123600Z"},
+		"X-Ignored":  {"ignored"},
+	})
+
+	expected := "GET\n" +
+		"/orders/my%20order\n" +
+		"a=hello%20world&b=2\n" +
+		"host:example.com\n" +
+		"x-amz-date:20150830T123600Z\n" +
+		"\n" +
+		"host;x-amz-date\n" +
+		"e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+	assert.Equal(t, expected, req.CanonicalRequest([]string{"X-Amz-Date", "Host"}))
+
+	t.Run("keys prefixing other keys", func(t *testing.T) {
+		req := NewInvokeMethodRequest("orders")
+		req.WithHTTPExtension("GET", "a1=2&a-b=3&a=1")
+		canonicalQuery := strings.Split(req.CanonicalRequest(nil), "\n")[2]
+		assert.Equal(t, "a=1&a-b=3&a1=2", canonicalQuery)
+	})
+
+	t.Run("no http extension", func(t *testing.T) {
+		req := NewInvokeMethodRequest("orders")
+		verb := strings.Split(req.CanonicalRequest(nil), "\n")[0]
+		assert.Equal(t, "POST", verb)
+	})
+}
+
+func TestBodyLooksCompressed(t *testing.T) {
+	testCases := []struct {
+		name     string
+		data     []byte
+		expected bool
+	}{
+		{"gzip magic", []byte{0x1f, 0x8b, 0x08, 0x00}, true},
+		{"zlib magic", []byte{0x78, 0x9c, 0x4b, 0x4c}, true},
+		{"zstd magic", []byte{0x28, 0xb5, 0x2f, 0xfd, 0x04}, true},
+		{"plain text", []byte("xylophone"), false},
+		{"text with zlib checksum", []byte("x = 5"), false},
+		{"empty body", nil, false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := NewInvokeMethodRequest("test_method")
+			req.WithRawData(tc.data, "application/octet-stream")
+			assert.Equal(t, tc.expected, req.BodyLooksCompressed())
+		})
+	}
+}
+
+func TestWithQueryStruct(t *testing.T) {
+	type query struct {
+		Name   string   `url:"name"`
+		Limit  int      `url:"limit"`
+		Tags   []string `url:"tags"`
+		Cursor string   `url:"cursor,omitempty"`
+		Skip   string   `url:"-"`
+	}
+
+	t.Run("string, int and slice fields", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method")
+		req.WithHTTPExtension("GET", "")
+		err := req.WithQueryStruct(query{Name: "orders", Limit: 10, Tags: []string{"a", "b"}, Skip: "skip"})
+		assert.NoError(t, err)
+		assert.Equal(t, "limit=10&name=orders&tags=a%2Cb", req.EncodeHTTPQueryString())
+	})
+
+	t.Run("omitempty field is set", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method")
+		err := req.WithQueryStruct(&query{Cursor: "next"})
+		assert.NoError(t, err)
+		assert.Equal(t, "next", req.Message().GetHttpExtension().GetQuerystring()["cursor"])
+	})
+
+	t.Run("not a struct", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method")
+		assert.Error(t, req.WithQueryStruct("name=orders"))
+	})
+}
+
+func TestVerifyContentMD5(t *testing.T) {
+	digest := md5.Sum([]byte("test")) //nolint:gosec
+
+	t.Run("matching", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method")
+		req.WithRawData([]byte("test"), "text/plain")
+		req.WithMetadata(map[string][]string{"Content-MD5": {base64.StdEncoding.EncodeToString(digest[:])}})
+		assert.NoError(t, req.VerifyContentMD5())
+	})
+
+	t.Run("mismatched", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method")
+		req.WithRawData([]byte("tampered"), "text/plain")
+		req.WithMetadata(map[string][]string{"Content-MD5": {base64.StdEncoding.EncodeToString(digest[:])}})
+		assert.Error(t, req.VerifyContentMD5())
+	})
+
+	t.Run("absent", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method")
+		req.WithRawData([]byte("test"), "text/plain")
+		assert.NoError(t, req.VerifyContentMD5())
+	})
+}
+
+func TestCanaryBucket(t *testing.T) {
+	t.Run("stable bucketing", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method")
+		req.WithMetadata(map[string][]string{"x-user-id": {"user-42"}})
+		first, ok := req.CanaryBucket("x-user-id", 100)
+		assert.True(t, ok)
+		for i := 0; i < 10; i++ {
+			bucket, _ := req.CanaryBucket("x-user-id", 100)
+			assert.Equal(t, first, bucket)
+		}
+	})
+
+	t.Run("even distribution", func(t *testing.T) {
+		counts := make([]int, 4)
+		for i := 0; i < 4000; i++ {
+			req := NewInvokeMethodRequest("test_method")
+			req.WithMetadata(map[string][]string{"x-user-id": {fmt.Sprintf("user-%d", i)}})
+			bucket, ok := req.CanaryBucket("x-user-id", 4)
+			assert.True(t, ok)
+			counts[bucket]++
+		}
+		for _, count := range counts {
+			assert.InDelta(t, 1000, count, 200)
+		}
+	})
+
+	t.Run("absent header", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method")
+		_, ok := req.CanaryBucket("x-user-id", 100)
+		assert.False(t, ok)
+	})
+}
+
+func TestMessagePack(t *testing.T) {
+	body := []byte(`{"order":{"id":"1","items":[{"name":"apple","qty":2}]},"paid":true}`)
+
+	t.Run("round trip", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method")
+		req.WithRawData(body, "application/json")
+
+		assert.NoError(t, req.ToMessagePack())
+		contentType, packed := req.RawData()
+		assert.Equal(t, "application/msgpack", contentType)
+		assert.NotEqual(t, body, packed)
+
+		assert.NoError(t, req.ToJSON())
+		contentType, data := req.RawData()
+		assert.Equal(t, "application/json", contentType)
+		assert.JSONEq(t, string(body), string(data))
+	})
+
+	t.Run("integer field", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method")
+		req.WithRawData([]byte(`{"qty":2,"price":1.5}`), "application/json")
+		assert.NoError(t, req.ToMessagePack())
+
+		var item struct {
+			Qty   int     `msgpack:"qty"`
+			Price float64 `msgpack:"price"`
+		}
+		_, packed := req.RawData()
+		assert.NoError(t, msgpack.Unmarshal(packed, &item))
+		assert.Equal(t, 2, item.Qty)
+		assert.Equal(t, 1.5, item.Price)
+	})
+
+	t.Run("id above 2^53", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method")
+		req.WithRawData([]byte(`{"id":12345678901234567}`), "application/json")
+		assert.NoError(t, req.ToMessagePack())
+		assert.NoError(t, req.ToJSON())
+		_, data := req.RawData()
+		assert.Equal(t, `{"id":12345678901234567}`, string(data))
+	})
+
+	t.Run("trailing data", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method")
+		req.WithRawData([]byte(`{"id":1} {}`), "application/json")
+		assert.Error(t, req.ToMessagePack())
+	})
+
+	t.Run("unsupported content type", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method")
+		req.WithRawData([]byte("text"), "text/plain")
+		assert.Error(t, req.ToMessagePack())
+		assert.Error(t, req.ToJSON())
+	})
+}
+
+func TestHasDuplicateQueryKeys(t *testing.T) {
+	t.Run("with duplicates", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method")
+		req.WithHTTPExtension("GET", "b=1&a=1&b=2&c=1&a=2")
+		assert.Equal(t, []string{"a", "b"}, req.HasDuplicateQueryKeys())
+	})
+
+	t.Run("without duplicates", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method")
+		req.WithHTTPExtension("GET", "a=1&b=2")
+		assert.Empty(t, req.HasDuplicateQueryKeys())
+	})
+
+	t.Run("survives serialization", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method")
+		req.WithHTTPExtension("GET", "a=1&a=2")
+		received, err := InternalInvokeRequest(req.Proto())
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"a"}, received.HasDuplicateQueryKeys())
+	})
+
+	t.Run("updated by querystring changes", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method")
+		req.WithHTTPExtension("GET", "a=1&a=2&b=1&b=2&c=1&c=2")
+		req.MapQueryValues(func(key, value string) string {
+			if key == "a" {
+				return ""
+			}
+			return value
+		})
+		assert.NoError(t, req.WithQueryStruct(struct {
+			B string `url:"b"`
+		}{B: "3"}))
+		assert.Equal(t, []string{"c"}, req.HasDuplicateQueryKeys())
+	})
+
+	t.Run("forged header is stripped", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method")
+		req.WithHTTPExtension("GET", "a=1")
+		req.WithMetadata(map[string][]string{"Dapr-Duplicate-Query-Keys": {"a"}})
+		assert.Equal(t, []string{"a"}, req.HasDuplicateQueryKeys())
+		req.StripTrustHeaders()
+		assert.Empty(t, req.HasDuplicateQueryKeys())
+	})
+}
+
+func TestResponseContentType(t *testing.T) {
+	t.Run("set and get", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method").WithResponseContentType("application/xml")
+		ct, ok := req.ResponseContentType()
+		assert.True(t, ok)
+		assert.Equal(t, "application/xml", ct)
+	})
+
+	t.Run("absent", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method")
+		_, ok := req.ResponseContentType()
+		assert.False(t, ok)
+	})
+}
+
+func TestFallbackMethod(t *testing.T) {
+	t.Run("set and get", func(t *testing.T) {
+		req := NewInvokeMethodRequest("orders").WithFallbackMethod("orders/cached")
+		method, ok := req.FallbackMethod()
+		assert.True(t, ok)
+		assert.Equal(t, "orders/cached", method)
+	})
+
+	t.Run("absent", func(t *testing.T) {
+		req := NewInvokeMethodRequest("orders")
+		_, ok := req.FallbackMethod()
+		assert.False(t, ok)
+	})
+}
+
+func TestEnsureContentType(t *testing.T) {
+	t.Run("empty content type is sniffed", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method")
+		req.r.Message.Data = &any.Any{Value: []byte("<html><body>hello</body></html>")}
+		req.EnsureContentType()
+		assert.Equal(t, "text/html; charset=utf-8", req.ContentTypeRaw())
+	})
+
+	t.Run("empty content type without data is unchanged", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method")
+		req.EnsureContentType()
+		assert.Equal(t, "", req.ContentTypeRaw())
+	})
+
+	t.Run("preset content type is unchanged", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method")
+		req.WithRawData([]byte("<html></html>"), "application/xml")
+		req.EnsureContentType()
+		assert.Equal(t, "application/xml", req.ContentTypeRaw())
+	})
+}
+
+func TestValidateActorIDUUID(t *testing.T) {
+	t.Run("valid UUID", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method").WithActor("testActor", "6ba7b810-9dad-11d1-80b4-00c04fd430c8")
+		assert.NoError(t, req.ValidateActorIDUUID())
+	})
+
+	t.Run("invalid id", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method").WithActor("testActor", "1")
+		assert.Error(t, req.ValidateActorIDUUID())
+	})
+
+	t.Run("no actor", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method")
+		assert.NoError(t, req.ValidateActorIDUUID())
+	})
+}
+
+func TestTraceLinks(t *testing.T) {
+	links := []trace.Link{
+		{
+			TraceID: trace.TraceID{0x4b, 0xf9, 0x2f, 0x35, 0x77, 0xb3, 0x4d, 0xa6, 0xa3, 0xce, 0x92, 0x9d, 0x0e, 0x0e, 0x47, 0x36},
+			SpanID:  trace.SpanID{0x00, 0xf0, 0x67, 0xaa, 0x0b, 0xa9, 0x02, 0xb7},
+			Type:    trace.LinkTypeParent,
+		},
+		{
+			TraceID: trace.TraceID{0x0a, 0xf7, 0x65, 0x19, 0x16, 0xcd, 0x43, 0xdd, 0x84, 0x48, 0xeb, 0x21, 0x1c, 0x80, 0x31, 0x9c},
+			SpanID:  trace.SpanID{0xb7, 0xad, 0x6b, 0x71, 0x69, 0x20, 0x33, 0x31},
+			Type:    trace.LinkTypeChild,
+		},
+	}
+
+	req := NewInvokeMethodRequest("test_method").WithTraceLinks(links)
+	assert.Equal(t, links, req.TraceLinks())
+}
+
+func TestValidateMetadataEncoding(t *testing.T) {
+	t.Run("valid metadata", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method")
+		req.WithMetadata(map[string][]string{"x-name": {"héllo"}, "x-raw-bin": {"\xff\xfe"}})
+		assert.NoError(t, req.ValidateMetadataEncoding())
+	})
+
+	t.Run("non-ASCII key", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method")
+		req.WithMetadata(map[string][]string{"x-nämé": {"value"}})
+		assert.Error(t, req.ValidateMetadataEncoding())
+	})
+
+	t.Run("invalid UTF-8 value", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method")
+		req.WithMetadata(map[string][]string{"x-name": {"\xff\xfe"}})
+		assert.Error(t, req.ValidateMetadataEncoding())
+	})
+}
+
+func TestNormalizedVerb(t *testing.T) {
+	for _, verb := range []string{"get", "Get", "GET"} {
+		t.Run(verb, func(t *testing.T) {
+			req := NewInvokeMethodRequest("test_method")
+			req.WithHTTPExtension(verb, "")
+			assert.Equal(t, "GET", req.NormalizedVerb())
+		})
+	}
+
+	t.Run("no http extension", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method")
+		assert.Equal(t, "POST", req.NormalizedVerb())
+	})
+}
+
+func TestQueueTime(t *testing.T) {
+	t.Run("past send time", func(t *testing.T) {
+		now := time.Now()
+		req := NewInvokeMethodRequest("test_method").WithClientSendTime(now.Add(-150 * time.Millisecond))
+		d, ok := req.QueueTime(now)
+		assert.True(t, ok)
+		assert.Equal(t, 150*time.Millisecond, d)
+	})
+
+	t.Run("absent", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method")
+		_, ok := req.QueueTime(time.Now())
+		assert.False(t, ok)
+	})
+}
+
+func TestDPoPProof(t *testing.T) {
+	enc := base64.RawURLEncoding.EncodeToString
+	proof := enc([]byte(`{"typ":"dpop+jwt","alg":"ES256"}`)) + "." + enc([]byte(`{"htm":"POST"}`)) + "." + enc([]byte("signature"))
+
+	t.Run("well-formed proof", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method")
+		req.WithMetadata(map[string][]string{"DPoP": {proof}})
+		val, ok := req.DPoPProof()
+		assert.True(t, ok)
+		assert.Equal(t, proof, val)
+	})
+
+	t.Run("malformed proof", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method")
+		req.WithMetadata(map[string][]string{"DPoP": {"not.a-jwt"}})
+		_, ok := req.DPoPProof()
+		assert.False(t, ok)
+	})
+
+	t.Run("absent", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method")
+		_, ok := req.DPoPProof()
+		assert.False(t, ok)
+	})
+}
+
+func TestMapQueryValues(t *testing.T) {
+	t.Run("uppercase values", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method")
+		req.WithHTTPExtension("GET", "a=one&b=two")
+		req.MapQueryValues(func(key, value string) string {
+			return strings.ToUpper(value)
+		})
+		assert.Equal(t, "a=ONE&b=TWO", req.EncodeHTTPQueryString())
+	})
+
+	t.Run("empty value drops the key", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method")
+		req.WithHTTPExtension("GET", "a=one&secret=two")
+		req.MapQueryValues(func(key, value string) string {
+			if key == "secret" {
+				return ""
+			}
+			return value
+		})
+		assert.Equal(t, "a=one", req.EncodeHTTPQueryString())
+	})
+}
+
+func TestShardKey(t *testing.T) {
+	t.Run("set and get", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method").WithShardKey("customer-7")
+		key, ok := req.ShardKey()
+		assert.True(t, ok)
+		assert.Equal(t, "customer-7", key)
+	})
+
+	t.Run("absent", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method")
+		_, ok := req.ShardKey()
+		assert.False(t, ok)
+	})
+}
+
+func TestIsServerWideOptions(t *testing.T) {
+	testCases := []struct {
+		verb     string
+		method   string
+		expected bool
+	}{
+		{"OPTIONS", "*", true},
+		{"OPTIONS", "/foo", false},
+		{"GET", "*", false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.verb+" "+tc.method, func(t *testing.T) {
+			req := NewInvokeMethodRequest(tc.method)
+			req.WithHTTPExtension(tc.verb, "")
+			assert.Equal(t, tc.expected, req.IsServerWideOptions())
+		})
+	}
+}
+
+func TestSaga(t *testing.T) {
+	t.Run("set and get saga id", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method").WithSagaID("saga-1")
+		id, ok := req.SagaID()
+		assert.True(t, ok)
+		assert.Equal(t, "saga-1", id)
+	})
+
+	t.Run("absent saga id", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method")
+		_, ok := req.SagaID()
+		assert.False(t, ok)
+	})
+
+	t.Run("compensation flag", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method").WithSagaID("saga-1")
+		assert.False(t, req.IsCompensation())
+		req.WithCompensation(true)
+		assert.True(t, req.IsCompensation())
+		req.WithCompensation(false)
+		assert.False(t, req.IsCompensation())
+	})
+}
+
+func TestNeedsBase64Transport(t *testing.T) {
+	t.Run("octet-stream", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method")
+		req.WithRawData([]byte{0x00, 0x01, 0x02}, "application/octet-stream")
+		assert.True(t, req.NeedsBase64Transport())
+	})
+
+	t.Run("json", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method")
+		req.WithRawData([]byte(`{"a":1}`), "application/json; charset=utf-8")
+		assert.False(t, req.NeedsBase64Transport())
+	})
+
+	t.Run("text", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method")
+		req.WithRawData([]byte("hello"), "text/plain")
+		assert.False(t, req.NeedsBase64Transport())
+	})
+}
+
+func TestWrapBase64(t *testing.T) {
+	data := []byte{0x00, 0xff, 0x10, 0x20}
+	req := NewInvokeMethodRequest("test_method")
+	req.WithRawData(data, "application/octet-stream")
+
+	assert.NoError(t, req.WrapBase64())
+	contentType, wrapped := req.RawData()
+	assert.Equal(t, "text/plain", contentType)
+	assert.Equal(t, []byte(base64.StdEncoding.EncodeToString(data)), wrapped)
+	assert.Equal(t, "base64", req.Metadata()["content-transfer-encoding"].GetValues()[0])
+	assert.Error(t, req.WrapBase64())
+
+	assert.NoError(t, req.DecodeTransferEncoding())
+	contentType, decoded := req.RawData()
+	assert.Equal(t, "application/octet-stream", contentType)
+	assert.Equal(t, data, decoded)
+	_, ok := req.Metadata()["content-transfer-encoding"]
+	assert.False(t, ok)
+}
+
+func TestFeatureFlags(t *testing.T) {
+	flags := map[string]string{"new-checkout": "on", "theme": "dark mode", "limit": "a&b=c"}
+	req := NewInvokeMethodRequest("test_method").WithFeatureFlags(flags)
+	assert.Equal(t, flags, req.FeatureFlags())
+
+	t.Run("absent", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method")
+		assert.Empty(t, req.FeatureFlags())
+	})
+}
+
+func TestIsReplay(t *testing.T) {
+	seenKeys := map[string]bool{"key-1": true}
+	seen := func(key string) bool {
+		return seenKeys[key]
+	}
+
+	t.Run("seen key", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method")
+		req.WithMetadata(map[string][]string{"Idempotency-Key": {"key-1"}})
+		assert.True(t, req.IsReplay(seen))
+	})
+
+	t.Run("unseen key", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method")
+		req.WithMetadata(map[string][]string{"Idempotency-Key": {"key-2"}})
+		assert.False(t, req.IsReplay(seen))
+	})
+
+	t.Run("no key", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method")
+		assert.False(t, req.IsReplay(seen))
+	})
+}
+
+func TestSigningPayload(t *testing.T) {
+	t.Run("deterministic across reordered inputs", func(t *testing.T) {
+		req1 := NewInvokeMethodRequest("orders")
+		req1.WithHTTPExtension("POST", "b=2&a=1")
+		req1.WithMetadata(map[string][]string{"x-date": {"today"}, "host": {"example.com"}})
+		req1.WithRawData([]byte("body"), "text/plain")
+
+		req2 := NewInvokeMethodRequest("orders")
+		req2.WithHTTPExtension("post", "a=1&b=2")
+		req2.WithMetadata(map[string][]string{"Host": {"example.com"}, "X-Date": {"today"}})
+		req2.WithRawData([]byte("body"), "text/plain")
+
+		p1, err := req1.SigningPayload([]string{"host", "x-date"})
+		assert.NoError(t, err)
+		p2, err := req2.SigningPayload([]string{"X-Date", "Host"})
+		assert.NoError(t, err)
+		assert.Equal(t, p1, p2)
+		assert.Contains(t, string(p1), "host:example.com\nx-date:today\n")
+	})
+
+	t.Run("different body changes the payload", func(t *testing.T) {
+		req1 := NewInvokeMethodRequest("orders").WithRawData([]byte("body1"), "text/plain")
+		req2 := NewInvokeMethodRequest("orders").WithRawData([]byte("body2"), "text/plain")
+		p1, _ := req1.SigningPayload(nil)
+		p2, _ := req2.SigningPayload(nil)
+		assert.NotEqual(t, p1, p2)
+	})
+
+	t.Run("missing header", func(t *testing.T) {
+		req := NewInvokeMethodRequest("orders")
+		_, err := req.SigningPayload([]string{"host"})
+		assert.Error(t, err)
+	})
+
+	t.Run("no http extension", func(t *testing.T) {
+		payload, err := NewInvokeMethodRequest("orders").SigningPayload(nil)
+		assert.NoError(t, err)
+		assert.True(t, strings.HasPrefix(string(payload), "orders\nPOST\n"))
+	})
+}
+
+func TestDetectSmuggling(t *testing.T) {
+	testCases := []struct {
+		name      string
+		md        map[string][]string
+		expectErr bool
+	}{
+		{"both present", map[string][]string{"Content-Length": {"10"}, "Transfer-Encoding": {"chunked"}}, true},
+		{"content-length only", map[string][]string{"Content-Length": {"10"}}, false},
+		{"transfer-encoding only", map[string][]string{"Transfer-Encoding": {"chunked"}}, false},
+		{"neither", map[string][]string{}, false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := NewInvokeMethodRequest("test_method")
+			req.WithMetadata(tc.md)
+			if tc.expectErr {
+				assert.Error(t, req.DetectSmuggling())
+			} else {
+				assert.NoError(t, req.DetectSmuggling())
+			}
+		})
+	}
+}
+
+func TestPriorityClass(t *testing.T) {
+	for _, class := range []string{"low", "normal", "high", "critical"} {
+		t.Run(class, func(t *testing.T) {
+			req := NewInvokeMethodRequest("test_method").WithPriorityClass(class)
+			assert.Equal(t, class, req.PriorityClass())
+		})
+	}
+
+	t.Run("numeric priority", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method").WithPriorityClass("critical")
+		priority, ok := req.Priority()
+		assert.True(t, ok)
+		assert.Equal(t, 3, priority)
+	})
+
+	t.Run("unknown class defaults to normal", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method").WithPriorityClass("urgent")
+		assert.Equal(t, "normal", req.PriorityClass())
+	})
+
+	t.Run("absent", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method")
+		_, ok := req.Priority()
+		assert.False(t, ok)
+		assert.Equal(t, "normal", req.PriorityClass())
+	})
+}
+
+func TestCrossesTrustBoundary(t *testing.T) {
+	t.Run("internal", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method").WithOrigin(OriginInternal)
+		assert.False(t, req.CrossesTrustBoundary())
+	})
+
+	t.Run("external with identity", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method")
+		req.WithMetadata(map[string][]string{"dapr-mesh-identity": {"spiffe://cluster.local/ns/default/app"}})
+		req.WithOrigin(OriginExternal)
+		assert.False(t, req.CrossesTrustBoundary())
+	})
+
+	t.Run("external without identity", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method").WithOrigin(OriginExternal)
+		assert.True(t, req.CrossesTrustBoundary())
+	})
+
+	t.Run("external with forged headers", func(t *testing.T) {
+		var fastReq = fasthttp.AcquireRequest()
+		fastReq.Header.Add("Dapr-Origin", string(OriginInternal))
+		fastReq.Header.Add("Dapr-Mesh-Identity", "spiffe://cluster.local/ns/default/app")
+
+		req := NewInvokeMethodRequest("test_method").WithFastHTTPHeaders(&fastReq.Header)
+		assert.True(t, req.CrossesTrustBoundary())
+
+		req = NewInvokeMethodRequest("test_method")
+		req.WithMetadata(map[string][]string{"dapr-mesh-identity": {"spiffe://cluster.local/ns/default/app"}})
+		assert.True(t, req.StripTrustHeaders().CrossesTrustBoundary())
+	})
+}
+
+func TestStatusHint(t *testing.T) {
+	testCases := []struct {
+		code       codes.Code
+		httpStatus int
+	}{
+		{codes.OK, 200},
+		{codes.NotFound, 404},
+		{codes.PermissionDenied, 403},
+		{codes.Unavailable, 503},
+		{codes.DeadlineExceeded, 504},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.code.String(), func(t *testing.T) {
+			req := NewInvokeMethodRequest("test_method").WithStatusHint(tc.code)
+			code, ok := req.StatusHint()
+			assert.True(t, ok)
+			assert.Equal(t, tc.code, code)
+			httpStatus, ok := req.HTTPStatusHint()
+			assert.True(t, ok)
+			assert.Equal(t, tc.httpStatus, httpStatus)
+		})
+	}
+
+	t.Run("absent", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method")
+		_, ok := req.StatusHint()
+		assert.False(t, ok)
+		_, ok = req.HTTPStatusHint()
+		assert.False(t, ok)
+	})
+}
+
+func TestExtractTenantFromPath(t *testing.T) {
+	testCases := []struct {
+		method string
+		tenant string
+		rest   string
+		ok     bool
+	}{
+		{"t/acme/orders/1", "acme", "orders/1", true},
+		{"/t/acme/orders", "acme", "orders", true},
+		{"orders/1", "", "orders/1", false},
+		{"t//orders", "", "t//orders", false},
+		{"tenants/acme", "", "tenants/acme", false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.method, func(t *testing.T) {
+			req := NewInvokeMethodRequest(tc.method)
+			tenant, rest, ok := req.ExtractTenantFromPath()
+			assert.Equal(t, tc.ok, ok)
+			assert.Equal(t, tc.tenant, tenant)
+			assert.Equal(t, tc.rest, rest)
+		})
+	}
+}
+
+func TestIncrementHopCount(t *testing.T) {
+	t.Run("absent header starts at 0", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method")
+		hops, err := req.IncrementHopCount(3)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, hops)
+	})
+
+	t.Run("incrementing", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method")
+		req.WithMetadata(map[string][]string{"dapr-hop-count": {"1"}})
+		hops, err := req.IncrementHopCount(3)
+		assert.NoError(t, err)
+		assert.Equal(t, 2, hops)
+		assert.Equal(t, "2", req.Metadata()["dapr-hop-count"].GetValues()[0])
+	})
+
+	t.Run("reaching the max", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method")
+		req.WithMetadata(map[string][]string{"dapr-hop-count": {"3"}})
+		_, err := req.IncrementHopCount(3)
+		assert.Error(t, err)
+	})
+
+	t.Run("malformed header", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method")
+		req.WithMetadata(map[string][]string{"dapr-hop-count": {"many"}})
+		_, err := req.IncrementHopCount(3)
+		assert.Error(t, err)
+	})
+}
+
+func TestPseudoHeaders(t *testing.T) {
+	req := NewInvokeMethodRequest("orders/1")
+	req.WithHTTPExtension("GET", "expand=items")
+	headers := req.PseudoHeaders("localhost:3000")
+
+	assert.Equal(t, "GET", headers[":method"])
+	assert.Equal(t, "/orders/1?expand=items", headers[":path"])
+	assert.Equal(t, "localhost:3000", headers[":authority"])
+	assert.Equal(t, "http", headers[":scheme"])
+
+	t.Run("no http extension", func(t *testing.T) {
+		headers := NewInvokeMethodRequest("orders/1").PseudoHeaders("localhost:3000")
+		assert.Equal(t, "POST", headers[":method"])
+	})
+}
+
+func TestWebhookSignature(t *testing.T) {
+	secret := []byte("webhook-secret")
+	body := []byte(`{"action":"opened"}`)
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	signature := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+
+	t.Run("matching signature", func(t *testing.T) {
+		req := NewInvokeMethodRequest("webhook")
+		req.WithRawData(body, "application/json")
+		req.WithMetadata(map[string][]string{"X-Hub-Signature-256": {signature}})
+
+		sig, ok := req.WebhookSignature("x-hub-signature-256")
+		assert.True(t, ok)
+		assert.Equal(t, signature, sig)
+		assert.NoError(t, req.VerifyWebhookHMAC("x-hub-signature-256", secret))
+	})
+
+	t.Run("mismatching signature", func(t *testing.T) {
+		req := NewInvokeMethodRequest("webhook")
+		req.WithRawData([]byte(`{"action":"closed"}`), "application/json")
+		req.WithMetadata(map[string][]string{"X-Hub-Signature-256": {signature}})
+		assert.Error(t, req.VerifyWebhookHMAC("x-hub-signature-256", secret))
+	})
+
+	t.Run("absent signature", func(t *testing.T) {
+		req := NewInvokeMethodRequest("webhook")
+		_, ok := req.WebhookSignature("x-hub-signature-256")
+		assert.False(t, ok)
+		assert.Error(t, req.VerifyWebhookHMAC("x-hub-signature-256", secret))
+	})
+}
+
+func TestCollapseSlashes(t *testing.T) {
+	testCases := []struct {
+		method   string
+		expected string
+	}{
+		{"orders//123", "orders/123"},
+		{"//orders", "/orders"},
+		{"orders/123", "orders/123"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.method, func(t *testing.T) {
+			req := NewInvokeMethodRequest(tc.method).CollapseSlashes()
+			assert.Equal(t, tc.expected, req.Message().GetMethod())
+		})
+	}
+}
+
+func TestLogLevel(t *testing.T) {
+	t.Run("set and get", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method").WithLogLevel("DEBUG")
+		level, ok := req.LogLevel()
+		assert.True(t, ok)
+		assert.Equal(t, "debug", level)
+	})
+
+	t.Run("unknown level", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method").WithLogLevel("verbose")
+		_, ok := req.LogLevel()
+		assert.False(t, ok)
+	})
+
+	t.Run("absent", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method")
+		_, ok := req.LogLevel()
+		assert.False(t, ok)
+	})
+}
+
+func TestStripInternalHeaders(t *testing.T) {
+	req := NewInvokeMethodRequest("test_method")
+	req.WithMetadata(map[string][]string{
+		"destination-app-id": {"app"},
+		"Dapr-Api-Token":     {"token"},
+		"dapr-hop-count":     {"2"},
+		"traceparent":        {"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"},
+		"tracestate":         {"congo=t61rcWkgMzE"},
+		"x-user-header":      {"value"},
+	})
+	req.WithOrigin(OriginInternal).WithShardKey("shard")
+	req.StripInternalHeaders()
+
+	md := req.Metadata()
+	for _, hdr := range []string{"destination-app-id", "Dapr-Api-Token", "dapr-hop-count", "dapr-origin", "dapr-shard-key"} {
+		_, ok := md[hdr]
+		assert.False(t, ok, hdr)
+	}
+	for _, hdr := range []string{"traceparent", "tracestate", "x-user-header"} {
+		_, ok := md[hdr]
+		assert.True(t, ok, hdr)
+	}
+}
+
+func TestDeploymentColor(t *testing.T) {
+	t.Run("set and get", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method").WithDeploymentColor("Green")
+		color, ok := req.DeploymentColor()
+		assert.True(t, ok)
+		assert.Equal(t, "green", color)
+	})
+
+	t.Run("invalid color", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method").WithDeploymentColor("purple")
+		_, ok := req.DeploymentColor()
+		assert.False(t, ok)
+	})
+
+	t.Run("absent", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method")
+		_, ok := req.DeploymentColor()
+		assert.False(t, ok)
+	})
+}
+
+func TestWantsProblemJSON(t *testing.T) {
+	t.Run("problem+json accept", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method")
+		req.WithMetadata(map[string][]string{"Accept": {"application/json, application/problem+json;q=0.9"}})
+		assert.True(t, req.WantsProblemJSON())
+	})
+
+	t.Run("plain json accept", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method")
+		req.WithMetadata(map[string][]string{"Accept": {"application/json"}})
+		assert.False(t, req.WantsProblemJSON())
+	})
+}
+
+func TestODataFilters(t *testing.T) {
+	t.Run("full OData query", func(t *testing.T) {
+		req := NewInvokeMethodRequest("orders")
+		req.WithHTTPExtension("GET", url.Values{
+			"$filter":  {"price lt 10"},
+			"$top":     {"5"},
+			"$skip":    {"10"},
+			"$orderby": {"price desc"},
+		}.Encode())
+
+		filters, err := req.ODataFilters()
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]string{
+			"filter":  "price lt 10",
+			"top":     "5",
+			"skip":    "10",
+			"orderby": "price desc",
+		}, filters)
+	})
+
+	t.Run("plain query", func(t *testing.T) {
+		req := NewInvokeMethodRequest("orders")
+		req.WithHTTPExtension("GET", "page=1")
+		filters, err := req.ODataFilters()
+		assert.NoError(t, err)
+		assert.Empty(t, filters)
+	})
+
+	t.Run("invalid $top", func(t *testing.T) {
+		req := NewInvokeMethodRequest("orders")
+		req.WithHTTPExtension("GET", "$top=all")
+		_, err := req.ODataFilters()
+		assert.Error(t, err)
+	})
+}
+
+func TestAcceptVersion(t *testing.T) {
+	t.Run("vendor media type", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method")
+		req.WithMetadata(map[string][]string{"Accept": {"application/vnd.acme.v3+json"}})
+		vendor, version, format, ok := req.AcceptVersion()
+		assert.True(t, ok)
+		assert.Equal(t, "acme", vendor)
+		assert.Equal(t, "3", version)
+		assert.Equal(t, "json", format)
+	})
+
+	t.Run("plain json accept", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method")
+		req.WithMetadata(map[string][]string{"Accept": {"application/json"}})
+		_, _, _, ok := req.AcceptVersion()
+		assert.False(t, ok)
+	})
+}
+
+func TestLockToken(t *testing.T) {
+	t.Run("set and get", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method").WithLockToken("lock-1")
+		token, ok := req.LockToken()
+		assert.True(t, ok)
+		assert.Equal(t, "lock-1", token)
+	})
+
+	t.Run("absent", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method")
+		_, ok := req.LockToken()
+		assert.False(t, ok)
+	})
+}
+
+func TestDebugDump(t *testing.T) {
+	req := NewInvokeMethodRequest("orders")
+	req.WithHTTPExtension("POST", "")
+	req.WithMetadata(map[string][]string{
+		"Authorization": {"Bearer secret-token"},
+		"Cookie":        {"session=secret-session"},
+		"x-request-id":  {"req-1"},
+	})
+	req.WithRawData([]byte("0123456789abcdefghij"), "text/plain")
+
+	dump := req.DebugDump(8)
+
+	t.Run("request fields", func(t *testing.T) {
+		assert.Contains(t, dump, "method: orders")
+		assert.Contains(t, dump, "verb: POST")
+		assert.Contains(t, dump, "version: V1")
+		assert.Contains(t, dump, "x-request-id: req-1")
+	})
+
+	t.Run("sensitive headers are redacted", func(t *testing.T) {
+		assert.NotContains(t, dump, "secret-token")
+		assert.NotContains(t, dump, "secret-session")
+		assert.Contains(t, dump, "Authorization: <redacted>")
+	})
+
+	t.Run("body is truncated", func(t *testing.T) {
+		assert.Contains(t, dump, "body: 20 bytes")
+		assert.Contains(t, dump, "01234567")
+		assert.NotContains(t, dump, "89abcdef")
+		assert.Contains(t, dump, "... 12 more bytes")
+	})
+}
+
+func TestConsistency(t *testing.T) {
+	for _, level := range []string{"eventual", "strong"} {
+		t.Run(level, func(t *testing.T) {
+			req := NewInvokeMethodRequest("test_method").WithConsistency(level)
+			val, ok := req.Consistency()
+			assert.True(t, ok)
+			assert.Equal(t, level, val)
+		})
+	}
+
+	t.Run("invalid level", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method").WithConsistency("linearizable")
+		_, ok := req.Consistency()
+		assert.False(t, ok)
+	})
+
+	t.Run("absent", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method")
+		_, ok := req.Consistency()
+		assert.False(t, ok)
+	})
+}
+
+func TestSupportsResume(t *testing.T) {
+	t.Run("present", func(t *testing.T) {
+		req := NewInvokeMethodRequest("upload")
+		req.WithMetadata(map[string][]string{"Dapr-Resume": {"true"}})
+		assert.True(t, req.SupportsResume())
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		req := NewInvokeMethodRequest("upload")
+		req.WithMetadata(map[string][]string{"Dapr-Resume": {"false"}})
+		assert.False(t, req.SupportsResume())
+	})
+
+	t.Run("absent", func(t *testing.T) {
+		req := NewInvokeMethodRequest("upload")
+		assert.False(t, req.SupportsResume())
+	})
+}
+
+func TestBypassCircuitBreaker(t *testing.T) {
+	t.Run("set", func(t *testing.T) {
+		req := NewInvokeMethodRequest("admin").WithBypassCircuitBreaker()
+		assert.True(t, req.BypassCircuitBreaker())
+	})
+
+	t.Run("default false", func(t *testing.T) {
+		req := NewInvokeMethodRequest("admin")
+		assert.False(t, req.BypassCircuitBreaker())
+	})
+}
+
+func TestStructuredField(t *testing.T) {
+	t.Run("dictionary", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method")
+		req.WithMetadata(map[string][]string{"Priority": {"u=1, i"}})
+		sf, err := req.StructuredField("priority")
+		assert.NoError(t, err)
+		assert.Equal(t, SFKindDictionary, sf.Kind)
+		assert.Equal(t, []SFDictMember{
+			{Key: "u", Item: SFItem{Value: int64(1)}},
+			{Key: "i", Item: SFItem{Value: true}},
+		}, sf.Dictionary)
+	})
+
+	t.Run("list", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method")
+		req.WithMetadata(map[string][]string{"x-list": {`"foo";a=1, bar, (1 2)`}})
+		sf, err := req.StructuredField("x-list")
+		assert.NoError(t, err)
+		assert.Equal(t, SFKindList, sf.Kind)
+		assert.Equal(t, []SFItem{
+			{Value: "foo", Params: []SFParam{{Key: "a", Value: int64(1)}}},
+			{Value: SFToken("bar")},
+			{InnerList: []SFItem{{Value: int64(1)}, {Value: int64(2)}}},
+		}, sf.List)
+	})
+
+	t.Run("item", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method")
+		req.WithMetadata(map[string][]string{"x-item": {"4.5;unit=s"}})
+		sf, err := req.StructuredField("x-item")
+		assert.NoError(t, err)
+		assert.Equal(t, SFKindItem, sf.Kind)
+		assert.Equal(t, SFItem{Value: 4.5, Params: []SFParam{{Key: "unit", Value: SFToken("s")}}}, sf.Item)
+	})
+
+	t.Run("absent header", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method")
+		_, err := req.StructuredField("priority")
+		assert.Error(t, err)
+	})
+}
+
+func TestFairQueueKey(t *testing.T) {
+	testCases := []struct {
+		method   string
+		class    string
+		expected string
+	}{
+		{"t/acme/orders", "high", "acme/high"},
+		{"t/acme/orders", "low", "acme/low"},
+		{"t/globex/orders", "high", "globex/high"},
+		{"orders", "critical", "_default/critical"},
+	}
+
+	keys := map[string]bool{}
+	for _, tc := range testCases {
+		t.Run(tc.expected, func(t *testing.T) {
+			req := NewInvokeMethodRequest(tc.method).WithPriorityClass(tc.class)
+			key := req.FairQueueKey()
+			assert.Equal(t, tc.expected, key)
+			keys[key] = true
+		})
+	}
+	assert.Len(t, keys, len(testCases))
+}
+
+func TestRequestedSchema(t *testing.T) {
+	t.Run("set and get", func(t *testing.T) {
+		req := NewInvokeMethodRequest("orders")
+		req.WithMetadata(map[string][]string{"Dapr-Response-Schema": {"order-summary/v2"}})
+		schema, ok := req.RequestedSchema()
+		assert.True(t, ok)
+		assert.Equal(t, "order-summary/v2", schema)
+	})
+
+	t.Run("absent", func(t *testing.T) {
+		req := NewInvokeMethodRequest("orders")
+		_, ok := req.RequestedSchema()
+		assert.False(t, ok)
+	})
+}
+
+func TestStripProxyPrefix(t *testing.T) {
+	testCases := []struct {
+		name     string
+		method   string
+		prefix   string
+		expected string
+	}{
+		{"matching prefix", "/api/orders/1", "/api/", "orders/1"},
+		{"matching prefix without slashes", "api/orders", "api", "orders"},
+		{"non-matching prefix", "apiv2/orders", "/api/", "apiv2/orders"},
+		{"empty prefix", "api/orders", "", "api/orders"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := NewInvokeMethodRequest(tc.method).StripProxyPrefix(tc.prefix)
+			assert.Equal(t, tc.expected, req.Message().GetMethod())
+		})
+	}
+}
+
+func TestDataRegion(t *testing.T) {
+	t.Run("set and get", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method").WithDataRegion("eu-west")
+		region, ok := req.DataRegion()
+		assert.True(t, ok)
+		assert.Equal(t, "eu-west", region)
+	})
+
+	t.Run("absent", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method")
+		_, ok := req.DataRegion()
+		assert.False(t, ok)
+	})
+}
+
+func TestIsActorDeactivation(t *testing.T) {
+	testCases := []struct {
+		name     string
+		verb     string
+		method   string
+		expected bool
+	}{
+		{"deactivation", "DELETE", "actors/testActor/1", true},
+		{"actor method", "PUT", "actors/testActor/1/method/foo", false},
+		{"GET on actor", "GET", "actors/testActor/1", false},
+		{"DELETE on actor method", "DELETE", "actors/testActor/1/method/foo", false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := NewInvokeMethodRequest(tc.method)
+			req.WithHTTPExtension(tc.verb, "")
+			assert.Equal(t, tc.expected, req.IsActorDeactivation())
+		})
+	}
+}
+
+func TestNewActorStateTransactionRequest(t *testing.T) {
+	t.Run("multiple operations", func(t *testing.T) {
+		ops := []TransactionOp{
+			{Operation: "upsert", Request: map[string]interface{}{"key": "key1", "value": "value1"}},
+			{Operation: "delete", Request: map[string]interface{}{"key": "key2"}},
+		}
+		req, err := NewActorStateTransactionRequest("testActor", "1", ops)
+		assert.NoError(t, err)
+		assert.Equal(t, "actors/testActor/1/state", req.Message().GetMethod())
+		assert.Equal(t, commonv1pb.HTTPExtension_POST, req.Message().GetHttpExtension().GetVerb())
+		assert.Equal(t, "testActor", req.Actor().GetActorType())
+
+		contentType, data := req.RawData()
+		assert.Equal(t, "application/json", contentType)
+		assert.JSONEq(t, `[{"operation":"upsert","request":{"key":"key1","value":"value1"}},{"operation":"delete","request":{"key":"key2"}}]`, string(data))
+	})
+
+	t.Run("empty operations", func(t *testing.T) {
+		_, err := NewActorStateTransactionRequest("testActor", "1", nil)
+		assert.Error(t, err)
+	})
+}
+
+func TestContextValue(t *testing.T) {
+	t.Run("set and get", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method").WithContextValue("tenant", "acme")
+		val, ok := req.ContextValue("tenant")
+		assert.True(t, ok)
+		assert.Equal(t, "acme", val)
+
+		_, ok = req.ContextValue("absent")
+		assert.False(t, ok)
+	})
+
+	t.Run("stripped before app delivery", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method")
+		req.WithMetadata(map[string][]string{"x-user-header": {"value"}})
+		req.WithContextValue("tenant", "acme").WithContextValue("Stage", "auth")
+		req.StripInternalHeaders()
+
+		_, ok := req.ContextValue("tenant")
+		assert.False(t, ok)
+		_, ok = req.ContextValue("Stage")
+		assert.False(t, ok)
+		assert.Len(t, req.Metadata(), 1)
+	})
+}
+
+func TestKeepAliveParams(t *testing.T) {
+	t.Run("full header", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method")
+		req.WithMetadata(map[string][]string{"Keep-Alive": {"timeout=5, max=1000"}})
+		timeout, max, ok := req.KeepAliveParams()
+		assert.True(t, ok)
+		assert.Equal(t, 5, timeout)
+		assert.Equal(t, 1000, max)
+	})
+
+	t.Run("absent header", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method")
+		_, _, ok := req.KeepAliveParams()
+		assert.False(t, ok)
+	})
+}
+
+func TestSamplingRatio(t *testing.T) {
+	t.Run("valid ratio", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method").WithSamplingRatio(0.25)
+		r, ok := req.SamplingRatio()
+		assert.True(t, ok)
+		assert.Equal(t, 0.25, r)
+	})
+
+	t.Run("out of range ratio is clamped", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method").WithSamplingRatio(1.5)
+		r, ok := req.SamplingRatio()
+		assert.True(t, ok)
+		assert.Equal(t, 1.0, r)
+
+		req.WithSamplingRatio(-0.5)
+		r, ok = req.SamplingRatio()
+		assert.True(t, ok)
+		assert.Equal(t, 0.0, r)
+	})
+
+	t.Run("out of range header", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method")
+		req.WithMetadata(map[string][]string{samplingRatioHeader: {"2"}})
+		_, ok := req.SamplingRatio()
+		assert.False(t, ok)
+	})
+
+	t.Run("NaN", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method").WithSamplingRatio(0.5).WithSamplingRatio(math.NaN())
+		_, ok := req.SamplingRatio()
+		assert.False(t, ok)
+
+		req.WithMetadata(map[string][]string{samplingRatioHeader: {"NaN"}})
+		_, ok = req.SamplingRatio()
+		assert.False(t, ok)
+	})
+
+	t.Run("absent", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method")
+		_, ok := req.SamplingRatio()
+		assert.False(t, ok)
+	})
+}
+
+func TestValidateHost(t *testing.T) {
+	t.Run("allowed host", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method")
+		req.WithMetadata(map[string][]string{"Host": {"api.example.com:8080"}})
+		assert.NoError(t, req.ValidateHost("api.example.com"))
+		assert.NoError(t, req.ValidateHost("API.example.com:8080"))
+		assert.Error(t, req.ValidateHost("api.example.com:9090"))
+	})
+
+	t.Run("allowed authority", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method")
+		req.WithMetadata(map[string][]string{":authority": {"api.example.com"}})
+		assert.NoError(t, req.ValidateHost("api.example.com"))
+	})
+
+	t.Run("disallowed host", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method")
+		req.WithMetadata(map[string][]string{"Host": {"evil.example.com"}})
+		assert.Error(t, req.ValidateHost("api.example.com"))
+	})
+
+	t.Run("absent host", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method")
+		assert.Error(t, req.ValidateHost("api.example.com"))
+	})
+}
+
+func TestPackMetadata(t *testing.T) {
+	req := NewInvokeMethodRequest("test_method")
+	req.WithMetadata(map[string][]string{
+		"x-single": {"value"},
+		"x-multi":  {"first", "second"},
+	})
+	blob, err := req.PackMetadata()
+	assert.NoError(t, err)
+
+	unpacked := NewInvokeMethodRequest("test_method")
+	assert.NoError(t, unpacked.UnpackMetadata(blob))
+	assert.Equal(t, []string{"value"}, unpacked.Metadata()["x-single"].GetValues())
+	assert.Equal(t, []string{"first", "second"}, unpacked.Metadata()["x-multi"].GetValues())
+
+	assert.Error(t, unpacked.UnpackMetadata([]byte{0xff}))
+}
+
+func TestMethodOrDefault(t *testing.T) {
+	assert.Equal(t, "index", NewInvokeMethodRequest("").MethodOrDefault("index"))
+	assert.Equal(t, "index", NewInvokeMethodRequest("/").MethodOrDefault("index"))
+	assert.Equal(t, "test_method", NewInvokeMethodRequest("test_method").MethodOrDefault("index"))
+}
+
+func TestSpanAttributes(t *testing.T) {
+	attrs := map[string]string{
+		"tenant":   "acme",
+		"route":    "/orders/{id}",
+		"region":   "west us",
+		"priority": "high&urgent",
+	}
+	req := NewInvokeMethodRequest("test_method").WithSpanAttributes(attrs)
+	assert.Equal(t, attrs, req.SpanAttributes())
+
+	assert.Empty(t, NewInvokeMethodRequest("test_method").SpanAttributes())
+}
+
+func TestTargetWeights(t *testing.T) {
+	t.Run("valid weights", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method")
+		req.WithMetadata(map[string][]string{targetWeightsHeader: {"v1=80, v2=20"}})
+		weights, ok := req.TargetWeights()
+		assert.True(t, ok)
+		assert.Equal(t, map[string]int{"v1": 80, "v2": 20}, weights)
+	})
+
+	t.Run("malformed weights", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method")
+		req.WithMetadata(map[string][]string{targetWeightsHeader: {"v1=80,v2"}})
+		_, ok := req.TargetWeights()
+		assert.False(t, ok)
+	})
+
+	t.Run("absent", func(t *testing.T) {
+		_, ok := NewInvokeMethodRequest("test_method").TargetWeights()
+		assert.False(t, ok)
+	})
+}
+
+func TestValidateReentrancy(t *testing.T) {
+	t.Run("enabled and missing id", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method").WithActor("testActor", "1")
+		assert.NoError(t, req.ValidateReentrancy(true))
+		id, ok := req.ReentrancyID()
+		assert.True(t, ok)
+		_, err := uuid.Parse(id)
+		assert.NoError(t, err)
+	})
+
+	t.Run("enabled and present id", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method").WithActor("testActor", "1")
+		req.WithMetadata(map[string][]string{reentrancyIDHeader: {"chain-1"}})
+		assert.NoError(t, req.ValidateReentrancy(true))
+		id, _ := req.ReentrancyID()
+		assert.Equal(t, "chain-1", id)
+	})
+
+	t.Run("enabled and empty id", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method").WithActor("testActor", "1")
+		req.WithMetadata(map[string][]string{reentrancyIDHeader: {""}})
+		assert.Error(t, req.ValidateReentrancy(true))
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method").WithActor("testActor", "1")
+		assert.NoError(t, req.ValidateReentrancy(false))
+		_, ok := req.ReentrancyID()
+		assert.False(t, ok)
+	})
+
+	t.Run("not an actor call", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method")
+		assert.NoError(t, req.ValidateReentrancy(true))
+		_, ok := req.ReentrancyID()
+		assert.False(t, ok)
+	})
+}
+
+func TestPayloadVersion(t *testing.T) {
+	t.Run("set and get", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method").WithPayloadVersion(3)
+		v, ok := req.PayloadVersion()
+		assert.True(t, ok)
+		assert.Equal(t, 3, v)
+		assert.Equal(t, internalv1pb.APIVersion_V1, req.APIVersion())
+	})
+
+	t.Run("absent", func(t *testing.T) {
+		_, ok := NewInvokeMethodRequest("test_method").PayloadVersion()
+		assert.False(t, ok)
+	})
+}
+
+func TestRejectNullBytes(t *testing.T) {
+	t.Run("null byte in value", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method")
+		req.WithMetadata(map[string][]string{"x-header": {"value", "trunc\x00ated"}})
+		assert.Error(t, req.RejectNullBytes())
+	})
+
+	t.Run("null byte in key", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method")
+		req.WithMetadata(map[string][]string{"x-\x00header": {"value"}})
+		assert.Error(t, req.RejectNullBytes())
+	})
+
+	t.Run("clean metadata", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method")
+		req.WithMetadata(map[string][]string{"x-header": {"value"}})
+		assert.NoError(t, req.RejectNullBytes())
+	})
+}
+
+func TestDiff(t *testing.T) {
+	req := NewInvokeMethodRequest("test_method")
+	req.WithHTTPExtension("GET", "")
+	req.WithRawData([]byte("test"), "text/plain")
+	req.WithMetadata(map[string][]string{"x-same": {"1"}, "x-changed": {"a"}, "x-removed": {"v"}})
+
+	other := NewInvokeMethodRequest("other_method")
+	other.WithHTTPExtension("POST", "")
+	other.WithRawData([]byte("longer"), "application/json")
+	other.WithMetadata(map[string][]string{"x-same": {"1"}, "x-changed": {"b"}, "x-added": {"v"}})
+
+	assert.Equal(t, []string{
+		"method: test_method != other_method",
+		"verb: GET != POST",
+		"content type: text/plain != application/json",
+		"body size: 4 != 6",
+		"metadata x-added: only in other request",
+		"metadata x-changed: a != b",
+		"metadata x-removed: only in this request",
+	}, req.Diff(other))
+
+	assert.Empty(t, req.Diff(req))
+
+	t.Run("nil other", func(t *testing.T) {
+		assert.Equal(t, []string{
+			"method: only in this request",
+			"verb: only in this request",
+			"content type: only in this request",
+			"body size: only in this request",
+			"metadata x-changed: only in this request",
+			"metadata x-removed: only in this request",
+			"metadata x-same: only in this request",
+		}, req.Diff(nil))
+	})
+}
+
+func TestDeprecationInfo(t *testing.T) {
+	t.Run("both headers", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method")
+		req.WithMetadata(map[string][]string{
+			"Deprecation": {"true"},
+			"Sunset":      {"Sat, 31 Dec 2022 23:59:59 GMT"},
+		})
+		deprecated, sunset, ok := req.DeprecationInfo()
+		assert.True(t, ok)
+		assert.True(t, deprecated)
+		assert.Equal(t, time.Date(2022, 12, 31, 23, 59, 59, 0, time.UTC), sunset)
+	})
+
+	t.Run("deprecation date", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method")
+		req.WithMetadata(map[string][]string{"Deprecation": {"Fri, 01 Jan 2021 00:00:00 GMT"}})
+		deprecated, sunset, ok := req.DeprecationInfo()
+		assert.True(t, ok)
+		assert.True(t, deprecated)
+		assert.True(t, sunset.IsZero())
+	})
+
+	t.Run("malformed sunset", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method")
+		req.WithMetadata(map[string][]string{"Sunset": {"tomorrow"}})
+		_, _, ok := req.DeprecationInfo()
+		assert.False(t, ok)
+	})
+
+	t.Run("absent", func(t *testing.T) {
+		_, _, ok := NewInvokeMethodRequest("test_method").DeprecationInfo()
+		assert.False(t, ok)
+	})
+}
+
+func TestNewTopicDeliveryRequest(t *testing.T) {
+	req := NewTopicDeliveryRequest("pubsub", "orders", []byte(`{"id":1}`), JSONContentType)
+	assert.Equal(t, "orders", req.Message().GetMethod())
+	assert.Equal(t, commonv1pb.HTTPExtension_POST, req.Message().GetHttpExtension().GetVerb())
+
+	pubsubName, _ := req.metadataValue(pubsubNameHeader)
+	assert.Equal(t, "pubsub", pubsubName)
+	topic, _ := req.metadataValue(topicHeader)
+	assert.Equal(t, "orders", topic)
+
+	contentType, data := req.RawData()
+	assert.Equal(t, JSONContentType, contentType)
+	assert.Equal(t, []byte(`{"id":1}`), data)
+
+	t.Run("subscription route", func(t *testing.T) {
+		req := NewTopicDeliveryRequest("pubsub", "orders", nil, JSONContentType).WithRoute("orders/new")
+		assert.Equal(t, "orders/new", req.Message().GetMethod())
+		topic, _ := req.metadataValue(topicHeader)
+		assert.Equal(t, "orders", topic)
+
+		req.WithRoute("")
+		assert.Equal(t, "orders/new", req.Message().GetMethod())
+	})
+}
+
+func TestBindingMetadata(t *testing.T) {
+	md := map[string]string{"blobName": "image.png", "container": "uploads"}
+	req := NewInvokeMethodRequest("test_method").WithBindingMetadata("storage", md)
+
+	name, ok := req.BindingName()
+	assert.True(t, ok)
+	assert.Equal(t, "storage", name)
+	assert.Equal(t, md, req.BindingMetadata())
+
+	_, ok = NewInvokeMethodRequest("test_method").BindingName()
+	assert.False(t, ok)
+}
+
+func TestIsScheduled(t *testing.T) {
+	t.Run("cron binding", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method")
+		req.WithMetadata(map[string][]string{"Dapr-Binding-Type": {"cron"}})
+		assert.True(t, req.IsScheduled())
+	})
+
+	t.Run("other binding", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method")
+		req.WithMetadata(map[string][]string{bindingTypeHeader: {"kafka"}})
+		assert.False(t, req.IsScheduled())
+	})
+
+	t.Run("absent", func(t *testing.T) {
+		assert.False(t, NewInvokeMethodRequest("test_method").IsScheduled())
+	})
+}
+
+func TestDeadLetterTopic(t *testing.T) {
+	t.Run("set and get", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method").WithDeadLetterTopic("orders-dlq")
+		topic, ok := req.DeadLetterTopic()
+		assert.True(t, ok)
+		assert.Equal(t, "orders-dlq", topic)
+	})
+
+	t.Run("absent", func(t *testing.T) {
+		_, ok := NewInvokeMethodRequest("test_method").DeadLetterTopic()
+		assert.False(t, ok)
+	})
+}
+
+func TestPartitionKey(t *testing.T) {
+	t.Run("stable partition", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method")
+		req.WithMetadata(map[string][]string{"x-order-id": {"order-42"}})
+		partition, ok := req.PartitionKey("x-order-id", 8)
+		assert.True(t, ok)
+		assert.True(t, partition >= 0 && partition < 8)
+
+		other := NewInvokeMethodRequest("other_method")
+		other.WithMetadata(map[string][]string{"X-Order-Id": {"order-42"}})
+		otherPartition, _ := other.PartitionKey("x-order-id", 8)
+		assert.Equal(t, partition, otherPartition)
+	})
+
+	t.Run("absent header", func(t *testing.T) {
+		_, ok := NewInvokeMethodRequest("test_method").PartitionKey("x-order-id", 8)
+		assert.False(t, ok)
+	})
+}
+
+func TestTTL(t *testing.T) {
+	t.Run("future ttl", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method").WithTTL(time.Minute)
+		ttl, ok := req.TTL()
+		assert.True(t, ok)
+		assert.True(t, ttl > 0 && ttl <= time.Minute)
+		assert.False(t, req.IsExpired(time.Now()))
+		assert.True(t, req.IsExpired(time.Now().Add(2*time.Minute)))
+	})
+
+	t.Run("expired ttl", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method").WithTTL(-time.Second)
+		ttl, ok := req.TTL()
+		assert.True(t, ok)
+		assert.True(t, ttl < 0)
+		assert.True(t, req.IsExpired(time.Now()))
+	})
+
+	t.Run("absent", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method")
+		_, ok := req.TTL()
+		assert.False(t, ok)
+		assert.False(t, req.IsExpired(time.Now()))
+	})
+}
+
+func TestAckTokens(t *testing.T) {
+	t.Run("multiple tokens", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method")
+		req.WithMetadata(map[string][]string{"Dapr-Ack-Tokens": {"t1, t2,,t3"}})
+		assert.Equal(t, []string{"t1", "t2", "t3"}, req.AckTokens())
+	})
+
+	t.Run("absent", func(t *testing.T) {
+		assert.Empty(t, NewInvokeMethodRequest("test_method").AckTokens())
+	})
+}
+
+func TestSequence(t *testing.T) {
+	t.Run("set and get", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method").WithSequence(42)
+		seq, ok := req.Sequence()
+		assert.True(t, ok)
+		assert.Equal(t, uint64(42), seq)
+	})
+
+	t.Run("zero sequence", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method").WithSequence(0)
+		seq, ok := req.Sequence()
+		assert.True(t, ok)
+		assert.Equal(t, uint64(0), seq)
+	})
+
+	t.Run("absent", func(t *testing.T) {
+		_, ok := NewInvokeMethodRequest("test_method").Sequence()
+		assert.False(t, ok)
+	})
+}
+
+func TestRedactedClone(t *testing.T) {
+	req := NewInvokeMethodRequest("test_method")
+	req.WithRawData([]byte(`{"ssn":"123-45-6789"}`), JSONContentType)
+	req.WithMetadata(map[string][]string{
+		"Authorization": {"Bearer token"},
+		"X-Session":     {"session-1", "session-2"},
+		"X-Request-Id":  {"1"},
+	})
+
+	clone := req.RedactedClone("x-session")
+
+	contentType, data := clone.RawData()
+	assert.Equal(t, JSONContentType, contentType)
+	assert.Equal(t, []byte(redactedValue), data)
+	assert.Equal(t, []string{redactedValue}, clone.Metadata()["Authorization"].GetValues())
+	assert.Equal(t, []string{redactedValue, redactedValue}, clone.Metadata()["X-Session"].GetValues())
+	assert.Equal(t, []string{"1"}, clone.Metadata()["X-Request-Id"].GetValues())
+	assert.Equal(t, "test_method", clone.Message().GetMethod())
+
+	_, data = req.RawData()
+	assert.Equal(t, []byte(`{"ssn":"123-45-6789"}`), data)
+	assert.Equal(t, []string{"Bearer token"}, req.Metadata()["Authorization"].GetValues())
+	assert.Equal(t, []string{"session-1", "session-2"}, req.Metadata()["X-Session"].GetValues())
+}
+
+func TestMaxConcurrency(t *testing.T) {
+	t.Run("set and get", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method").WithMaxConcurrency(4)
+		n, ok := req.MaxConcurrency()
+		assert.True(t, ok)
+		assert.Equal(t, 4, n)
+	})
+
+	t.Run("absent", func(t *testing.T) {
+		_, ok := NewInvokeMethodRequest("test_method").MaxConcurrency()
+		assert.False(t, ok)
+	})
+}
+
+func TestValidateQueryParams(t *testing.T) {
+	t.Run("only allowed params", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method").WithHTTPExtension("GET", "page=1&size=10")
+		assert.NoError(t, req.ValidateQueryParams("page", "size", "sort"))
+	})
+
+	t.Run("unexpected param", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method").WithHTTPExtension("GET", "page=1&debug=true")
+		err := req.ValidateQueryParams("page", "size")
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "debug")
+	})
+
+	t.Run("no params", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method").WithHTTPExtension("GET", "")
+		assert.NoError(t, req.ValidateQueryParams("page"))
+	})
+}
+
+func TestTranscodeCharset(t *testing.T) {
+	t.Run("utf-8 to latin-1 and back", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method")
+		req.WithRawData([]byte("café"), "text/plain")
+
+		assert.NoError(t, req.TranscodeCharset("ISO-8859-1"))
+		contentType, data := req.RawData()
+		assert.Equal(t, "text/plain; charset=ISO-8859-1", contentType)
+		assert.Equal(t, []byte{'c', 'a', 'f', 0xe9}, data)
+
+		assert.NoError(t, req.TranscodeCharset("UTF-8"))
+		contentType, data = req.RawData()
+		assert.Equal(t, "text/plain; charset=UTF-8", contentType)
+		assert.Equal(t, []byte("café"), data)
+	})
+
+	t.Run("unrepresentable character", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method")
+		req.WithRawData([]byte("日本"), "text/plain; charset=utf-8")
+		assert.Error(t, req.TranscodeCharset("ISO-8859-1"))
+	})
+
+	t.Run("unknown charset", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method")
+		req.WithRawData([]byte("text"), "text/plain")
+		assert.Error(t, req.TranscodeCharset("no-such-charset"))
+	})
+
+	t.Run("binary body", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method")
+		req.WithRawData([]byte{0x01}, "application/octet-stream")
+		assert.Error(t, req.TranscodeCharset("ISO-8859-1"))
+	})
+}
+
+func TestWarnings(t *testing.T) {
+	t.Run("single warning", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method")
+		req.WithMetadata(map[string][]string{"Warning": {`110 proxy.example.com "Response is \"stale\""`}})
+		assert.Equal(t, []WarningHeader{
+			{Code: 110, Agent: "proxy.example.com", Text: `Response is "stale"`},
+		}, req.Warnings())
+	})
+
+	t.Run("multiple warnings", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method")
+		req.WithMetadata(map[string][]string{"Warning": {
+			`112 - "Disconnected, operating offline" "Wed, 21 Oct 2015 07:28:00 GMT", 199 cache:8080 "Miscellaneous"`,
+			`214 gateway "Transformation applied"`,
+		}})
+		assert.Equal(t, []WarningHeader{
+			{Code: 112, Agent: "-", Text: "Disconnected, operating offline", Date: time.Date(2015, 10, 21, 7, 28, 0, 0, time.UTC)},
+			{Code: 199, Agent: "cache:8080", Text: "Miscellaneous"},
+			{Code: 214, Agent: "gateway", Text: "Transformation applied"},
+		}, req.Warnings())
+	})
+
+	t.Run("malformed warning", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method")
+		req.WithMetadata(map[string][]string{"Warning": {`199 agent "valid", oops`}})
+		assert.Equal(t, []WarningHeader{{Code: 199, Agent: "agent", Text: "valid"}}, req.Warnings())
+	})
+
+	t.Run("absent", func(t *testing.T) {
+		assert.Empty(t, NewInvokeMethodRequest("test_method").Warnings())
+	})
+}
+
+func TestClientHints(t *testing.T) {
+	t.Run("several hints", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method")
+		req.WithMetadata(map[string][]string{
+			"Sec-CH-UA":          {`"Chromium";v="92"`},
+			"sec-ch-ua-mobile":   {"?0"},
+			"Sec-CH-UA-Platform": {`"Linux"`},
+			"user-agent":         {"Mozilla/5.0"},
+		})
+		assert.Equal(t, map[string]string{
+			"sec-ch-ua":          `"Chromium";v="92"`,
+			"sec-ch-ua-mobile":   "?0",
+			"sec-ch-ua-platform": `"Linux"`,
+		}, req.ClientHints())
+	})
+
+	t.Run("no hints", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method")
+		req.WithMetadata(map[string][]string{"user-agent": {"Mozilla/5.0"}})
+		assert.Empty(t, req.ClientHints())
+	})
+}
+
+func TestGraphQLOperation(t *testing.T) {
+	t.Run("query with variables", func(t *testing.T) {
+		req := NewInvokeMethodRequest("graphql")
+		req.WithRawData([]byte(`{
+			"query": "query GetOrder($id: ID!) { order(id: $id) { total } }",
+			"operationName": "GetOrder",
+			"variables": {"id": "42", "expand": true}
+		}`), JSONContentType)
+		query, operationName, variables, ok := req.GraphQLOperation()
+		assert.True(t, ok)
+		assert.Equal(t, "query GetOrder($id: ID!) { order(id: $id) { total } }", query)
+		assert.Equal(t, "GetOrder", operationName)
+		assert.Equal(t, map[string]interface{}{"id": "42", "expand": true}, variables)
+	})
+
+	t.Run("non-GraphQL body", func(t *testing.T) {
+		req := NewInvokeMethodRequest("orders")
+		req.WithRawData([]byte(`{"id":42}`), JSONContentType)
+		_, _, _, ok := req.GraphQLOperation()
+		assert.False(t, ok)
+
+		req.WithRawData([]byte("plain text"), "text/plain")
+		_, _, _, ok = req.GraphQLOperation()
+		assert.False(t, ok)
+	})
+}
+
+func TestCaseSensitiveRouting(t *testing.T) {
+	t.Run("on and off", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method").WithCaseSensitiveRouting(true)
+		assert.True(t, req.CaseSensitiveRouting())
+
+		req.WithCaseSensitiveRouting(false)
+		assert.False(t, req.CaseSensitiveRouting())
+	})
+
+	t.Run("default", func(t *testing.T) {
+		assert.False(t, NewInvokeMethodRequest("test_method").CaseSensitiveRouting())
+	})
+}
+
+func TestMetadataJSON(t *testing.T) {
+	t.Run("valid JSON header", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method")
+		req.WithMetadata(map[string][]string{"X-Context": {`{"tenant":"acme","tier":2}`}})
+		var out struct {
+			Tenant string `json:"tenant"`
+			Tier   int    `json:"tier"`
+		}
+		assert.NoError(t, req.MetadataJSON("x-context", &out))
+		assert.Equal(t, "acme", out.Tenant)
+		assert.Equal(t, 2, out.Tier)
+	})
+
+	t.Run("invalid JSON header", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method")
+		req.WithMetadata(map[string][]string{"X-Context": {"tenant=acme"}})
+		var out map[string]interface{}
+		assert.Error(t, req.MetadataJSON("x-context", &out))
+	})
+
+	t.Run("missing key", func(t *testing.T) {
+		var out map[string]interface{}
+		assert.Error(t, NewInvokeMethodRequest("test_method").MetadataJSON("x-context", &out))
+	})
+}
+
+func TestCacheHint(t *testing.T) {
+	t.Run("private hint", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method").WithCacheHint(time.Minute, true)
+		maxAge, private, ok := req.CacheHint()
+		assert.True(t, ok)
+		assert.True(t, private)
+		assert.Equal(t, time.Minute, maxAge)
+	})
+
+	t.Run("public hint", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method").WithCacheHint(90*time.Second, false)
+		val, _ := req.metadataValue(cacheHintHeader)
+		assert.Equal(t, "public, max-age=90", val)
+		maxAge, private, ok := req.CacheHint()
+		assert.True(t, ok)
+		assert.False(t, private)
+		assert.Equal(t, 90*time.Second, maxAge)
+	})
+
+	t.Run("absent", func(t *testing.T) {
+		_, _, ok := NewInvokeMethodRequest("test_method").CacheHint()
+		assert.False(t, ok)
+	})
+}
+
+func TestLongPollTimeout(t *testing.T) {
+	t.Run("valid wait param", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method").WithHTTPExtension("GET", "wait=30s")
+		d, ok := req.LongPollTimeout()
+		assert.True(t, ok)
+		assert.Equal(t, 30*time.Second, d)
+
+		req.WithHTTPExtension("GET", "timeout=45")
+		d, ok = req.LongPollTimeout()
+		assert.True(t, ok)
+		assert.Equal(t, 45*time.Second, d)
+	})
+
+	t.Run("invalid value", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method").WithHTTPExtension("GET", "wait=forever")
+		_, ok := req.LongPollTimeout()
+		assert.False(t, ok)
+	})
+
+	t.Run("absent", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method").WithHTTPExtension("GET", "page=1")
+		_, ok := req.LongPollTimeout()
+		assert.False(t, ok)
+	})
+}
+
+func TestMinTLSVersion(t *testing.T) {
+	t.Run("set and get", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method").WithMinTLSVersion(tls.VersionTLS12)
+		v, ok := req.MinTLSVersion()
+		assert.True(t, ok)
+		assert.Equal(t, uint16(tls.VersionTLS12), v)
+	})
+
+	t.Run("absent", func(t *testing.T) {
+		_, ok := NewInvokeMethodRequest("test_method").MinTLSVersion()
+		assert.False(t, ok)
+	})
+}
+
+func TestAcceptedCharsets(t *testing.T) {
+	t.Run("charsets with q-values", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method")
+		req.WithMetadata(map[string][]string{"Accept-Charset": {"iso-8859-1;q=0.5, UTF-8, us-ascii;q=0"}})
+		assert.Equal(t, []string{"utf-8", "iso-8859-1"}, req.AcceptedCharsets())
+	})
+
+	t.Run("absent", func(t *testing.T) {
+		assert.Empty(t, NewInvokeMethodRequest("test_method").AcceptedCharsets())
+	})
+}
+
+func TestRetryPolicy(t *testing.T) {
+	t.Run("set and get", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method").WithRetryPolicy(3, 200*time.Millisecond)
+		policy, ok := req.RetryPolicy()
+		assert.True(t, ok)
+		assert.Equal(t, RetryPolicy{MaxRetries: 3, Backoff: 200 * time.Millisecond}, policy)
+	})
+
+	t.Run("malformed values", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method")
+		req.WithMetadata(map[string][]string{retryPolicyHeader: {"{maxRetries: 3"}})
+		_, ok := req.RetryPolicy()
+		assert.False(t, ok)
+
+		req.WithRetryPolicy(-1, time.Second)
+		_, ok = req.RetryPolicy()
+		assert.False(t, ok)
+	})
+
+	t.Run("absent", func(t *testing.T) {
+		_, ok := NewInvokeMethodRequest("test_method").RetryPolicy()
+		assert.False(t, ok)
+	})
+}
+
+func TestBreakGlass(t *testing.T) {
+	t.Run("set and get", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method").WithBreakGlass("incident 1234")
+		reason, ok := req.BreakGlass()
+		assert.True(t, ok)
+		assert.Equal(t, "incident 1234", reason)
+	})
+
+	t.Run("empty reason is rejected", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method").WithBreakGlass("  ")
+		_, ok := req.BreakGlass()
+		assert.False(t, ok)
+
+		req.WithMetadata(map[string][]string{breakGlassHeader: {""}})
+		_, ok = req.BreakGlass()
+		assert.False(t, ok)
+	})
+
+	t.Run("absent", func(t *testing.T) {
+		_, ok := NewInvokeMethodRequest("test_method").BreakGlass()
+		assert.False(t, ok)
+	})
+
+	t.Run("stripped from external callers", func(t *testing.T) {
+		var fastReq = fasthttp.AcquireRequest()
+		fastReq.Header.Add("Dapr-Break-Glass", "forged")
+
+		req := NewInvokeMethodRequest("test_method").WithFastHTTPHeaders(&fastReq.Header)
+		_, ok := req.BreakGlass()
+		assert.False(t, ok)
+
+		req = NewInvokeMethodRequest("test_method")
+		req.WithMetadata(map[string][]string{"Dapr-Break-Glass": {"forged"}}).StripTrustHeaders()
+		_, ok = req.BreakGlass()
+		assert.False(t, ok)
+	})
+}
+
+func TestSamplingKey(t *testing.T) {
+	t.Run("set and get", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method").WithSamplingKey("checkout-7")
+		key, ok := req.SamplingKey()
+		assert.True(t, ok)
+		assert.Equal(t, "checkout-7", key)
+	})
+
+	t.Run("absent", func(t *testing.T) {
+		_, ok := NewInvokeMethodRequest("test_method").SamplingKey()
+		assert.False(t, ok)
+	})
+}
+
+func TestStripTopologyHeaders(t *testing.T) {
+	req := NewInvokeMethodRequest("test_method")
+	req.WithMetadata(map[string][]string{
+		"Via":               {"1.1 gateway"},
+		"Forwarded":         {"for=10.0.0.1"},
+		"X-Forwarded-For":   {"10.0.0.1"},
+		"x-forwarded-host":  {"internal.svc"},
+		"X-Forwarded-Proto": {"http"},
+		"Server":            {"envoy"},
+		"X-User-Header":     {"value"},
+		"content-type":      {"application/json"},
+	})
+	req.StripTopologyHeaders()
+
+	assert.Equal(t, DaprInternalMetadata{
+		"X-User-Header": {Values: []string{"value"}},
+		"content-type":  {Values: []string{"application/json"}},
+	}, req.Metadata())
+}
+
+func TestPageRequest(t *testing.T) {
+	t.Run("valid values", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method").WithPageRequest(2, 50)
+		page, size, ok := req.PageRequest()
+		assert.True(t, ok)
+		assert.Equal(t, 2, page)
+		assert.Equal(t, 50, size)
+		assert.Equal(t, "page=2&size=50", req.EncodeHTTPQueryString())
+	})
+
+	t.Run("invalid values", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method").WithPageRequest(0, 50)
+		_, _, ok := req.PageRequest()
+		assert.False(t, ok)
+
+		req.WithPageRequest(1, -10)
+		_, _, ok = req.PageRequest()
+		assert.False(t, ok)
+
+		req.WithHTTPExtension("GET", "page=x&size=10")
+		_, _, ok = req.PageRequest()
+		assert.False(t, ok)
+	})
+
+	t.Run("absent", func(t *testing.T) {
+		_, _, ok := NewInvokeMethodRequest("test_method").PageRequest()
+		assert.False(t, ok)
+	})
+}
+
+func TestOpenTracingCarrier(t *testing.T) {
+	tracer := mocktracer.New()
+	span := tracer.StartSpan("test_method")
+	span.SetBaggageItem("tenant", "acme")
+	defer span.Finish()
+
+	req := NewInvokeMethodRequest("test_method")
+	req.WithMetadata(map[string][]string{"X-User-Header": {"value"}})
+	assert.NoError(t, tracer.Inject(span.Context(), opentracing.TextMap, req.OpenTracingCarrier()))
+	assert.NotEmpty(t, req.Metadata()["mockpfx-ids-traceid"])
+	assert.Equal(t, []string{"value"}, req.Metadata()["X-User-Header"].GetValues())
+
+	extracted, err := tracer.Extract(opentracing.TextMap, req.OpenTracingCarrier())
+	assert.NoError(t, err)
+	spanContext := span.Context().(mocktracer.MockSpanContext)
+	extractedContext := extracted.(mocktracer.MockSpanContext)
+	assert.Equal(t, spanContext.TraceID, extractedContext.TraceID)
+	assert.Equal(t, spanContext.SpanID, extractedContext.SpanID)
+	assert.Equal(t, "acme", extractedContext.Baggage["tenant"])
+}
+
+func TestIfMatchWeak(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		etags []string
+		weak  bool
+	}{
+		{"weak", `W/"v1"`, []string{"v1"}, true},
+		{"strong", `"v1", "v2"`, []string{"v1", "v2"}, false},
+		{"mixed", `"v1", W/"v2"`, []string{"v1", "v2"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := NewInvokeMethodRequest("test_method")
+			req.WithMetadata(map[string][]string{"If-Match": {tt.value}})
+			etags, weak, ok := req.IfMatchWeak()
+			assert.True(t, ok)
+			assert.Equal(t, tt.etags, etags)
+			assert.Equal(t, tt.weak, weak)
+		})
+	}
+
+	t.Run("absent", func(t *testing.T) {
+		_, _, ok := NewInvokeMethodRequest("test_method").IfMatchWeak()
+		assert.False(t, ok)
+	})
+}
+
+func TestCostUnits(t *testing.T) {
+	t.Run("set and get", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method").WithCostUnits(5)
+		units, ok := req.CostUnits()
+		assert.True(t, ok)
+		assert.Equal(t, 5, units)
+	})
+
+	t.Run("absent", func(t *testing.T) {
+		_, ok := NewInvokeMethodRequest("test_method").CostUnits()
+		assert.False(t, ok)
+	})
+}
+
+func TestIsStreamingMethod(t *testing.T) {
+	for _, streamType := range []string{"client", "server", "bidi", "BIDI"} {
+		t.Run(streamType, func(t *testing.T) {
+			req := NewInvokeMethodRequest("test_method")
+			req.WithMetadata(map[string][]string{"Dapr-Stream-Type": {streamType}})
+			assert.True(t, req.IsStreamingMethod())
+		})
+	}
+
+	t.Run("unary", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method")
+		assert.False(t, req.IsStreamingMethod())
+
+		req.WithMetadata(map[string][]string{streamTypeHeader: {"unary"}})
+		assert.False(t, req.IsStreamingMethod())
+	})
+}
+
+func TestWorkItem(t *testing.T) {
+	t.Run("round trip", func(t *testing.T) {
+		req := NewInvokeMethodRequest("orders/process")
+		req.WithHTTPExtension("PUT", "priority=high")
+		req.WithRawData([]byte(`{"id":42}`), JSONContentType)
+		req.WithMetadata(map[string][]string{"x-multi": {"first", "second"}})
+		req.WithActor("testActor", "1")
+
+		item, err := req.ToWorkItem()
+		assert.NoError(t, err)
+		assert.False(t, item.EnqueueTime.IsZero())
+
+		encoded, err := json.Marshal(item)
+		assert.NoError(t, err)
+		var decoded WorkItem
+		assert.NoError(t, json.Unmarshal(encoded, &decoded))
+
+		restored, err := FromWorkItem(&decoded)
+		assert.NoError(t, err)
+		assert.Equal(t, "orders/process", restored.Message().GetMethod())
+		assert.Equal(t, commonv1pb.HTTPExtension_PUT, restored.Message().GetHttpExtension().GetVerb())
+		assert.Equal(t, "priority=high", restored.EncodeHTTPQueryString())
+		contentType, data := restored.RawData()
+		assert.Equal(t, JSONContentType, contentType)
+		assert.Equal(t, []byte(`{"id":42}`), data)
+		assert.Equal(t, []string{"first", "second"}, restored.Metadata()["x-multi"].GetValues())
+		assert.Equal(t, "testActor", restored.Actor().GetActorType())
+		assert.Equal(t, "1", restored.Actor().GetActorId())
+	})
+
+	t.Run("no method", func(t *testing.T) {
+		_, err := NewInvokeMethodRequest("").ToWorkItem()
+		assert.Error(t, err)
+
+		_, err = FromWorkItem(&WorkItem{})
+		assert.Error(t, err)
+	})
+}
+
+func TestActorPartition(t *testing.T) {
+	t.Run("set and get", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method").WithActorPartition(7)
+		partition, ok := req.ActorPartition()
+		assert.True(t, ok)
+		assert.Equal(t, 7, partition)
+	})
+
+	t.Run("negative partition is rejected", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method").WithActorPartition(-1)
+		_, ok := req.ActorPartition()
+		assert.False(t, ok)
+
+		req.WithMetadata(map[string][]string{actorPartitionHeader: {"-3"}})
+		_, ok = req.ActorPartition()
+		assert.False(t, ok)
+	})
+
+	t.Run("absent", func(t *testing.T) {
+		_, ok := NewInvokeMethodRequest("test_method").ActorPartition()
+		assert.False(t, ok)
+	})
+}
+
+func TestVaryHeaders(t *testing.T) {
+	t.Run("list", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method")
+		req.WithMetadata(map[string][]string{"Vary": {"Accept-Encoding, accept", "ACCEPT-ENCODING,Origin"}})
+		assert.Equal(t, []string{"accept", "accept-encoding", "origin"}, req.VaryHeaders())
+	})
+
+	t.Run("star", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method")
+		req.WithMetadata(map[string][]string{"Vary": {"accept, *"}})
+		assert.Equal(t, []string{"*"}, req.VaryHeaders())
+	})
+
+	t.Run("absent", func(t *testing.T) {
+		assert.Empty(t, NewInvokeMethodRequest("test_method").VaryHeaders())
+	})
+}
+
+func TestVaryAwareCacheKey(t *testing.T) {
+	newRequest := func(acceptEncoding, requestID string) *InvokeMethodRequest {
+		req := NewInvokeMethodRequest("orders")
+		req.WithHTTPExtension("GET", "page=1")
+		req.WithMetadata(map[string][]string{
+			"Accept-Encoding": {acceptEncoding},
+			"X-Request-Id":    {requestID},
+		})
+		return req
+	}
+	vary := []string{"Accept-Encoding"}
+
+	key := newRequest("gzip", "1").VaryAwareCacheKey(vary)
+	assert.Len(t, key, 64)
+	assert.Equal(t, key, newRequest("gzip", "2").VaryAwareCacheKey(vary))
+	assert.NotEqual(t, key, newRequest("br", "1").VaryAwareCacheKey(vary))
+	assert.Empty(t, newRequest("gzip", "1").VaryAwareCacheKey([]string{"*"}))
+}
+
+func TestLocalizationContext(t *testing.T) {
+	t.Run("both present", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method").WithTimeZone("Europe/Paris")
+		req.r.Metadata["Accept-Language"] = &internalv1pb.ListStringValue{Values: []string{"en-US;q=0.8, fr-FR"}}
+		lang, tz, ok := req.LocalizationContext()
+		assert.True(t, ok)
+		assert.Equal(t, language.MustParse("fr-FR"), lang)
+		assert.Equal(t, "Europe/Paris", tz.String())
+	})
+
+	t.Run("only language", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method")
+		req.WithMetadata(map[string][]string{"Accept-Language": {"de"}})
+		lang, tz, ok := req.LocalizationContext()
+		assert.True(t, ok)
+		assert.Equal(t, language.German, lang)
+		assert.Nil(t, tz)
+	})
+
+	t.Run("only time zone", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method").WithTimeZone("Asia/Tokyo")
+		lang, tz, ok := req.LocalizationContext()
+		assert.True(t, ok)
+		assert.Equal(t, language.Und, lang)
+		assert.Equal(t, "Asia/Tokyo", tz.String())
+	})
+
+	t.Run("absent", func(t *testing.T) {
+		_, _, ok := NewInvokeMethodRequest("test_method").LocalizationContext()
+		assert.False(t, ok)
+	})
+}
+
+func TestValidateJSONDepth(t *testing.T) {
+	t.Run("shallow body", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method")
+		req.WithRawData([]byte(`{"order":{"items":[{"id":1}]}}`), JSONContentType)
+		assert.NoError(t, req.ValidateJSONDepth(4))
+	})
+
+	t.Run("deeply nested body", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method")
+		req.WithRawData([]byte(strings.Repeat("[", 100)+strings.Repeat("]", 100)), JSONContentType)
+		assert.Error(t, req.ValidateJSONDepth(32))
+	})
+
+	t.Run("non-JSON body", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method")
+		req.WithRawData([]byte(strings.Repeat("[", 100)), "text/plain")
+		assert.NoError(t, req.ValidateJSONDepth(32))
+	})
+}
+
+func TestExperiment(t *testing.T) {
+	req := NewInvokeMethodRequest("test_method")
+	req.WithExperiment("checkout-flow", "b").WithExperiment("pricing", "control")
+
+	variant, ok := req.Experiment("checkout-flow")
+	assert.True(t, ok)
+	assert.Equal(t, "b", variant)
+	variant, ok = req.Experiment("pricing")
+	assert.True(t, ok)
+	assert.Equal(t, "control", variant)
+
+	req.WithExperiment("checkout-flow", "a")
+	variant, _ = req.Experiment("checkout-flow")
+	assert.Equal(t, "a", variant)
+
+	_, ok = req.Experiment("search")
+	assert.False(t, ok)
+}
+
+func TestSameActorAs(t *testing.T) {
+	req := NewInvokeMethodRequest("method1").WithActor("testActor", "1")
+
+	assert.True(t, req.SameActorAs(NewInvokeMethodRequest("method2").WithActor("testActor", "1")))
+	assert.False(t, req.SameActorAs(NewInvokeMethodRequest("method1").WithActor("testActor", "2")))
+	assert.False(t, req.SameActorAs(NewInvokeMethodRequest("method1").WithActor("otherActor", "1")))
+	assert.False(t, req.SameActorAs(NewInvokeMethodRequest("method1")))
+	assert.False(t, NewInvokeMethodRequest("method1").SameActorAs(req))
+}
+
+func TestSetTraceStateEntry(t *testing.T) {
+	t.Run("add entry", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method")
+		req.WithMetadata(map[string][]string{"tracestate": {"congo=t61rcWkgMzE"}})
+		req.SetTraceStateEntry("rojo", "00f067aa0ba902b7")
+		val, _ := req.metadataValue(tracestateHeader)
+		assert.Equal(t, "rojo=00f067aa0ba902b7,congo=t61rcWkgMzE", val)
+	})
+
+	t.Run("update entry", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method")
+		req.WithMetadata(map[string][]string{"Tracestate": {"congo=t61rcWkgMzE, rojo=00f067aa0ba902b7"}})
+		req.SetTraceStateEntry("rojo", "b7ad6b7169203331")
+		val, _ := req.metadataValue(tracestateHeader)
+		assert.Equal(t, "rojo=b7ad6b7169203331,congo=t61rcWkgMzE", val)
+	})
+
+	t.Run("evict oldest entry at the size limit", func(t *testing.T) {
+		entries := make([]string, maxTraceStateEntries)
+		for i := range entries {
+			entries[i] = fmt.Sprintf("vendor%d=value%d", i, i)
+		}
+		req := NewInvokeMethodRequest("test_method")
+		req.WithMetadata(map[string][]string{"tracestate": {strings.Join(entries, ",")}})
+		req.SetTraceStateEntry("rojo", "00f067aa0ba902b7")
+
+		val, _ := req.metadataValue(tracestateHeader)
+		got := strings.Split(val, ",")
+		assert.Len(t, got, maxTraceStateEntries)
+		assert.Equal(t, "rojo=00f067aa0ba902b7", got[0])
+		assert.Equal(t, "vendor30=value30", got[maxTraceStateEntries-1])
+		assert.NotContains(t, val, "vendor31")
+	})
+
+	t.Run("invalid entry", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method").SetTraceStateEntry("ro=jo", "value")
+		_, ok := req.metadataValue(tracestateHeader)
+		assert.False(t, ok)
+	})
+
+	t.Run("invalid keys", func(t *testing.T) {
+		for _, key := range []string{"Rojo", "ro\tjo", "1rojo", strings.Repeat("a", 257), "tenant@Vendor", "tenant@" + strings.Repeat("v", 15)} {
+			req := NewInvokeMethodRequest("test_method").SetTraceStateEntry(key, "value")
+			_, ok := req.metadataValue(tracestateHeader)
+			assert.False(t, ok, key)
+		}
+	})
+
+	t.Run("multi-tenant key", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method").SetTraceStateEntry("acme@dapr", "value")
+		val, _ := req.metadataValue(tracestateHeader)
+		assert.Equal(t, "acme@dapr=value", val)
+	})
+}
+
+func TestIsBulkActorStateRequest(t *testing.T) {
+	t.Run("bulk request", func(t *testing.T) {
+		req := NewInvokeMethodRequest("actors/testActor/1/state/bulk")
+		req.WithRawData([]byte(`["key1","key2"]`), JSONContentType)
+		assert.True(t, req.IsBulkActorStateRequest())
+	})
+
+	t.Run("single key request", func(t *testing.T) {
+		req := NewInvokeMethodRequest("actors/testActor/1/state/key1")
+		assert.False(t, req.IsBulkActorStateRequest())
+
+		req = NewInvokeMethodRequest("actors/testActor/1/state/bulk")
+		req.WithRawData([]byte(`"key1"`), JSONContentType)
+		assert.False(t, req.IsBulkActorStateRequest())
+	})
+}
+
+func TestNewHealthCheckRequest(t *testing.T) {
+	req := NewHealthCheckRequest("orders")
+	assert.Equal(t, "healthz", req.Message().GetMethod())
+	assert.Equal(t, commonv1pb.HTTPExtension_GET, req.Message().GetHttpExtension().GetVerb())
+
+	appID, _ := req.metadataValue(DestinationIDHeader)
+	assert.Equal(t, "orders", appID)
+	source, ok := req.IsSynthetic()
+	assert.True(t, ok)
+	assert.Equal(t, "health-check", source)
+	ttl, ok := req.TTL()
+	assert.True(t, ok)
+	assert.True(t, ttl > 0 && ttl <= healthCheckTimeout)
+}
+
+func TestClientSDK(t *testing.T) {
+	t.Run("set and get", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method").WithClientSDK("dapr-sdk-go", "0.8.0")
+		name, version, ok := req.ClientSDK()
+		assert.True(t, ok)
+		assert.Equal(t, "dapr-sdk-go", name)
+		assert.Equal(t, "0.8.0", version)
+	})
+
+	t.Run("absent", func(t *testing.T) {
+		_, _, ok := NewInvokeMethodRequest("test_method").ClientSDK()
+		assert.False(t, ok)
+	})
+}
+
+func TestBearerAudience(t *testing.T) {
+	enc := base64.RawURLEncoding.EncodeToString
+	token := func(payload string) string {
+		return enc([]byte(`{"typ":"JWT","alg":"RS256"}`)) + "." + enc([]byte(payload)) + "." + enc([]byte("signature"))
+	}
+
+	t.Run("single audience", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method")
+		req.WithMetadata(map[string][]string{"Authorization": {"Bearer " + token(`{"aud":"orders"}`)}})
+		aud, err := req.BearerAudience()
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"orders"}, aud)
+	})
+
+	t.Run("multiple audiences", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method")
+		req.WithMetadata(map[string][]string{"Authorization": {"Bearer " + token(`{"aud":["orders","billing"]}`)}})
+		aud, err := req.BearerAudience()
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"orders", "billing"}, aud)
+	})
+
+	t.Run("no aud claim", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method")
+		req.WithMetadata(map[string][]string{"Authorization": {"Bearer " + token(`{"sub":"user"}`)}})
+		_, err := req.BearerAudience()
+		assert.Error(t, err)
+	})
+
+	t.Run("no bearer token", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method")
+		req.WithMetadata(map[string][]string{"Authorization": {"Basic dXNlcjpwYXNz"}})
+		_, err := req.BearerAudience()
+		assert.Error(t, err)
+	})
+}
+
+func TestTracingDisabled(t *testing.T) {
+	t.Run("set", func(t *testing.T) {
+		assert.True(t, NewInvokeMethodRequest("test_method").WithTracingDisabled().TracingDisabled())
+	})
+
+	t.Run("default", func(t *testing.T) {
+		assert.False(t, NewInvokeMethodRequest("test_method").TracingDisabled())
+	})
+}
+
+func TestHeaderEcho(t *testing.T) {
+	req := NewInvokeMethodRequest("test_method")
+	req.WithMetadata(map[string][]string{
+		"Content-Type":  {"application/json"},
+		"X-Multi":       {"first", "second"},
+		"Authorization": {"Bearer token"},
+		"Cookie":        {"session=1"},
+	})
+
+	var echo map[string][]string
+	assert.NoError(t, json.Unmarshal(req.HeaderEcho(), &echo))
+	assert.Equal(t, map[string][]string{
+		"content-type": {"application/json"},
+		"x-multi":      {"first", "second"},
+	}, echo)
+
+	t.Run("case variants match EchoResponse", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method")
+		req.WithMetadata(map[string][]string{"x-a": {"2"}, "X-A": {"1"}})
+
+		var echo map[string][]string
+		assert.NoError(t, json.Unmarshal(req.HeaderEcho(), &echo))
+		assert.Equal(t, map[string][]string{"x-a": {"1", "2"}}, echo)
+
+		var response struct {
+			Headers map[string][]string `json:"headers"`
+		}
+		_, body := req.EchoResponse()
+		assert.NoError(t, json.Unmarshal(body, &response))
+		assert.Equal(t, echo, response.Headers)
+	})
+}
+
+func TestSLO(t *testing.T) {
+	t.Run("set and get", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method").WithSLO("checkout-p99")
+		name, ok := req.SLO()
+		assert.True(t, ok)
+		assert.Equal(t, "checkout-p99", name)
+	})
+
+	t.Run("absent", func(t *testing.T) {
+		_, ok := NewInvokeMethodRequest("test_method").SLO()
+		assert.False(t, ok)
+	})
+}
+
+func TestValidateQueryValueLengths(t *testing.T) {
+	t.Run("under the limit", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method").WithHTTPExtension("GET", "q=short&page=1")
+		assert.NoError(t, req.ValidateQueryValueLengths(8))
+	})
+
+	t.Run("over the limit", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method").WithHTTPExtension("GET", "q="+strings.Repeat("a", 9))
+		assert.Error(t, req.ValidateQueryValueLengths(8))
+	})
+}
+
+func TestCompensationPayload(t *testing.T) {
+	payload := []byte{0x00, 0xff, '{', '}'}
+	req := NewInvokeMethodRequest("test_method")
+	req.WithRawData([]byte(`{"op":"reserve"}`), JSONContentType)
+	req.WithCompensationPayload(payload, "application/octet-stream")
+
+	data, contentType, ok := req.CompensationPayload()
+	assert.True(t, ok)
+	assert.Equal(t, payload, data)
+	assert.Equal(t, "application/octet-stream", contentType)
+	_, body := req.RawData()
+	assert.Equal(t, []byte(`{"op":"reserve"}`), body)
+
+	_, _, ok = NewInvokeMethodRequest("test_method").CompensationPayload()
+	assert.False(t, ok)
+}
+
+func TestStripProxyAuth(t *testing.T) {
+	req := NewInvokeMethodRequest("test_method")
+	req.WithMetadata(map[string][]string{
+		"Proxy-Authorization": {"Basic dXNlcjpwYXNz"},
+		"Authorization":       {"Bearer token"},
+	})
+	req.StripProxyAuth()
+
+	_, ok := req.metadataValue(proxyAuthorizationHeader)
+	assert.False(t, ok)
+	assert.Equal(t, []string{"Bearer token"}, req.AuthorizationValues())
+}
+
+func TestDataClassification(t *testing.T) {
+	t.Run("valid label", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method").WithDataClassification("Confidential")
+		label, ok := req.DataClassification()
+		assert.True(t, ok)
+		assert.Equal(t, "confidential", label)
+	})
+
+	t.Run("invalid label", func(t *testing.T) {
+		req := NewInvokeMethodRequest("test_method").WithDataClassification("secret")
+		_, ok := req.DataClassification()
+		assert.False(t, ok)
+
+		req.WithMetadata(map[string][]string{dataClassificationHeader: {"secret"}})
+		_, ok = req.DataClassification()
+		assert.False(t, ok)
+	})
+
+	t.Run("absent", func(t *testing.T) {
+		_, ok := NewInvokeMethodRequest("test_method").DataClassification()
+		assert.False(t, ok)
+	})
+}
@@ -0,0 +1,424 @@
+// ------------------------------------------------------------
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+// ------------------------------------------------------------
+
+package v1
+
+import (
+	"encoding/base64"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// SFKind is the top-level type of a structured field value (RFC 8941)
+type SFKind int
+
+const (
+	// SFKindItem is a single item
+	SFKindItem SFKind = iota
+	// SFKindList is a list of items and inner lists
+	SFKindList
+	// SFKindDictionary is an ordered map of keys to items and inner lists
+	SFKindDictionary
+)
+
+// SFToken is a structured field token, which is distinct from a string
+type SFToken string
+
+// SFParam is a parameter of a structured field item or inner list
+type SFParam struct {
+	Key string
+	// Value is an int64, float64, string, SFToken, []byte or bool
+	Value interface{}
+}
+
+// SFItem is a structured field item or inner list with its parameters
+type SFItem struct {
+	// Value is an int64, float64, string, SFToken, []byte or bool. It is nil for inner lists.
+	Value interface{}
+	// InnerList is set for inner lists
+	InnerList []SFItem
+	Params    []SFParam
+}
+
+// SFDictMember is a member of a structured field dictionary
+type SFDictMember struct {
+	Key  string
+	Item SFItem
+}
+
+// SFValue is a parsed structured field value
+type SFValue struct {
+	Kind SFKind
+	// Item is set when Kind is SFKindItem
+	Item SFItem
+	// List is set when Kind is SFKindList
+	List []SFItem
+	// Dictionary is set when Kind is SFKindDictionary
+	Dictionary []SFDictMember
+}
+
+// ParseStructuredField parses a structured field value. Header definitions tell whether
+// a field is an item, a list or a dictionary; as that is unknown here, a value is a
+// dictionary if all its members are keys and at least one of them has a value, an item
+// if it is a single item, and a list otherwise.
+func ParseStructuredField(input string) (*SFValue, error) {
+	dict, dictErr := parseSFDictionary(input)
+	if dictErr == nil {
+		for _, member := range dict {
+			if member.Item.Value != true || member.Item.InnerList != nil {
+				return &SFValue{Kind: SFKindDictionary, Dictionary: dict}, nil
+			}
+		}
+	}
+
+	p := &sfParser{s: strings.Trim(input, " ")}
+	list, err := p.parseList()
+	if err != nil {
+		return nil, err
+	}
+	if len(list) == 1 && list[0].InnerList == nil {
+		return &SFValue{Kind: SFKindItem, Item: list[0]}, nil
+	}
+	return &SFValue{Kind: SFKindList, List: list}, nil
+}
+
+func parseSFDictionary(input string) ([]SFDictMember, error) {
+	p := &sfParser{s: strings.Trim(input, " ")}
+	return p.parseDictionary()
+}
+
+// sfParser implements the parsing algorithms of RFC 8941 section 4.2
+type sfParser struct {
+	s string
+	i int
+}
+
+func (p *sfParser) eof() bool {
+	return p.i >= len(p.s)
+}
+
+func (p *sfParser) peek() byte {
+	if p.eof() {
+		return 0
+	}
+	return p.s[p.i]
+}
+
+func (p *sfParser) skipSP() {
+	for !p.eof() && p.s[p.i] == ' ' {
+		p.i++
+	}
+}
+
+func (p *sfParser) skipOWS() {
+	for !p.eof() && (p.s[p.i] == ' ' || p.s[p.i] == '\t') {
+		p.i++
+	}
+}
+
+// nextMember consumes the separator between list or dictionary members and returns
+// false at the end of the input
+func (p *sfParser) nextMember() (bool, error) {
+	p.skipOWS()
+	if p.eof() {
+		return false, nil
+	}
+	if p.peek() != ',' {
+		return false, errors.Errorf("expected comma at position %d", p.i)
+	}
+	p.i++
+	p.skipOWS()
+	if p.eof() {
+		return false, errors.New("trailing comma")
+	}
+	return true, nil
+}
+
+func (p *sfParser) parseList() ([]SFItem, error) {
+	var members []SFItem
+	for !p.eof() {
+		item, err := p.parseItemOrInnerList()
+		if err != nil {
+			return nil, err
+		}
+		members = append(members, item)
+		more, err := p.nextMember()
+		if err != nil {
+			return nil, err
+		}
+		if !more {
+			break
+		}
+	}
+	return members, nil
+}
+
+func (p *sfParser) parseDictionary() ([]SFDictMember, error) {
+	var members []SFDictMember
+	for !p.eof() {
+		key, err := p.parseKey()
+		if err != nil {
+			return nil, err
+		}
+
+		var item SFItem
+		if p.peek() == '=' {
+			p.i++
+			if item, err = p.parseItemOrInnerList(); err != nil {
+				return nil, err
+			}
+		} else {
+			item.Value = true
+			if item.Params, err = p.parseParameters(); err != nil {
+				return nil, err
+			}
+		}
+
+		// the last value of a duplicated key wins but keeps its original position
+		replaced := false
+		for i := range members {
+			if members[i].Key == key {
+				members[i].Item = item
+				replaced = true
+			}
+		}
+		if !replaced {
+			members = append(members, SFDictMember{Key: key, Item: item})
+		}
+
+		more, err := p.nextMember()
+		if err != nil {
+			return nil, err
+		}
+		if !more {
+			break
+		}
+	}
+	return members, nil
+}
+
+func (p *sfParser) parseItemOrInnerList() (SFItem, error) {
+	if p.peek() == '(' {
+		return p.parseInnerList()
+	}
+	return p.parseItem()
+}
+
+func (p *sfParser) parseInnerList() (SFItem, error) {
+	// consume the opening parenthesis
+	p.i++
+	inner := []SFItem{}
+	for !p.eof() {
+		p.skipSP()
+		if p.peek() == ')' {
+			p.i++
+			params, err := p.parseParameters()
+			if err != nil {
+				return SFItem{}, err
+			}
+			return SFItem{InnerList: inner, Params: params}, nil
+		}
+		item, err := p.parseItem()
+		if err != nil {
+			return SFItem{}, err
+		}
+		inner = append(inner, item)
+		if c := p.peek(); c != ' ' && c != ')' {
+			return SFItem{}, errors.Errorf("unexpected character in inner list at position %d", p.i)
+		}
+	}
+	return SFItem{}, errors.New("unterminated inner list")
+}
+
+func (p *sfParser) parseItem() (SFItem, error) {
+	value, err := p.parseBareItem()
+	if err != nil {
+		return SFItem{}, err
+	}
+	params, err := p.parseParameters()
+	if err != nil {
+		return SFItem{}, err
+	}
+	return SFItem{Value: value, Params: params}, nil
+}
+
+func (p *sfParser) parseParameters() ([]SFParam, error) {
+	var params []SFParam
+	for p.peek() == ';' {
+		p.i++
+		p.skipSP()
+		key, err := p.parseKey()
+		if err != nil {
+			return nil, err
+		}
+		var value interface{} = true
+		if p.peek() == '=' {
+			p.i++
+			if value, err = p.parseBareItem(); err != nil {
+				return nil, err
+			}
+		}
+		params = append(params, SFParam{Key: key, Value: value})
+	}
+	return params, nil
+}
+
+func (p *sfParser) parseKey() (string, error) {
+	c := p.peek()
+	if !isLCAlpha(c) && c != '*' {
+		return "", errors.Errorf("invalid key at position %d", p.i)
+	}
+	start := p.i
+	for !p.eof() {
+		c = p.s[p.i]
+		if !isLCAlpha(c) && !isDigit(c) && c != '_' && c != '-' && c != '.' && c != '*' {
+			break
+		}
+		p.i++
+	}
+	return p.s[start:p.i], nil
+}
+
+func (p *sfParser) parseBareItem() (interface{}, error) {
+	c := p.peek()
+	switch {
+	case c == '-' || isDigit(c):
+		return p.parseNumber()
+	case c == '"':
+		return p.parseString()
+	case c == '*' || isAlpha(c):
+		return p.parseToken(), nil
+	case c == ':':
+		return p.parseByteSequence()
+	case c == '?':
+		return p.parseBoolean()
+	}
+	return nil, errors.Errorf("invalid bare item at position %d", p.i)
+}
+
+func (p *sfParser) parseNumber() (interface{}, error) {
+	start := p.i
+	if p.peek() == '-' {
+		p.i++
+	}
+	if !isDigit(p.peek()) {
+		return nil, errors.Errorf("invalid number at position %d", start)
+	}
+
+	decimal := false
+	for !p.eof() {
+		c := p.s[p.i]
+		if isDigit(c) {
+			p.i++
+		} else if c == '.' && !decimal {
+			decimal = true
+			p.i++
+		} else {
+			break
+		}
+	}
+
+	num := p.s[start:p.i]
+	digits := strings.TrimPrefix(num, "-")
+	if !decimal {
+		if len(digits) > 15 {
+			return nil, errors.Errorf("integer %s is too long", num)
+		}
+		return strconv.ParseInt(num, 10, 64)
+	}
+
+	parts := strings.SplitN(digits, ".", 2)
+	if len(parts[0]) > 12 || len(parts[1]) == 0 || len(parts[1]) > 3 {
+		return nil, errors.Errorf("invalid decimal %s", num)
+	}
+	return strconv.ParseFloat(num, 64)
+}
+
+func (p *sfParser) parseString() (string, error) {
+	// consume the opening quote
+	p.i++
+	var b strings.Builder
+	for !p.eof() {
+		c := p.s[p.i]
+		p.i++
+		switch {
+		case c == '\\':
+			if p.eof() || (p.s[p.i] != '"' && p.s[p.i] != '\\') {
+				return "", errors.New("invalid escape in string")
+			}
+			b.WriteByte(p.s[p.i])
+			p.i++
+		case c == '"':
+			return b.String(), nil
+		case c < 0x20 || c > 0x7e:
+			return "", errors.New("invalid character in string")
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "", errors.New("unterminated string")
+}
+
+func (p *sfParser) parseToken() SFToken {
+	start := p.i
+	p.i++
+	for !p.eof() {
+		c := p.s[p.i]
+		if !isTChar(c) && c != ':' && c != '/' {
+			break
+		}
+		p.i++
+	}
+	return SFToken(p.s[start:p.i])
+}
+
+func (p *sfParser) parseByteSequence() ([]byte, error) {
+	// consume the opening colon
+	p.i++
+	end := strings.IndexByte(p.s[p.i:], ':')
+	if end < 0 {
+		return nil, errors.New("unterminated byte sequence")
+	}
+	encoded := p.s[p.i : p.i+end]
+	p.i += end + 1
+	decoded, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid byte sequence")
+	}
+	return decoded, nil
+}
+
+func (p *sfParser) parseBoolean() (bool, error) {
+	// consume the question mark
+	p.i++
+	switch p.peek() {
+	case '1':
+		p.i++
+		return true, nil
+	case '0':
+		p.i++
+		return false, nil
+	}
+	return false, errors.Errorf("invalid boolean at position %d", p.i)
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
+
+func isLCAlpha(c byte) bool {
+	return 'a' <= c && c <= 'z'
+}
+
+func isAlpha(c byte) bool {
+	return isLCAlpha(c) || ('A' <= c && c <= 'Z')
+}
+
+// isTChar returns true for the token characters of RFC 7230
+func isTChar(c byte) bool {
+	return isAlpha(c) || isDigit(c) || strings.IndexByte("!#$%&'*+-.^_`|~", c) >= 0
+}
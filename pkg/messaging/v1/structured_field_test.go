@@ -0,0 +1,54 @@
+// ------------------------------------------------------------
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+// ------------------------------------------------------------
+
+package v1
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseStructuredField(t *testing.T) {
+	t.Run("bare items", func(t *testing.T) {
+		sf, err := ParseStructuredField(`-42, "a \"quoted\" \\ string", ?0, :aGVsbG8=:, */*`)
+		assert.NoError(t, err)
+		assert.Equal(t, SFKindList, sf.Kind)
+		assert.Equal(t, []SFItem{
+			{Value: int64(-42)},
+			{Value: `a "quoted" \ string`},
+			{Value: false},
+			{Value: []byte("hello")},
+			{Value: SFToken("*/*")},
+		}, sf.List)
+	})
+
+	t.Run("list of tokens", func(t *testing.T) {
+		sf, err := ParseStructuredField("sugar, tea, rum")
+		assert.NoError(t, err)
+		assert.Equal(t, SFKindList, sf.Kind)
+		assert.Len(t, sf.List, 3)
+	})
+
+	t.Run("dictionary with inner list", func(t *testing.T) {
+		sf, err := ParseStructuredField(`a=(1 2);q=0.5, b=?1`)
+		assert.NoError(t, err)
+		assert.Equal(t, SFKindDictionary, sf.Kind)
+		assert.Equal(t, []SFDictMember{
+			{Key: "a", Item: SFItem{
+				InnerList: []SFItem{{Value: int64(1)}, {Value: int64(2)}},
+				Params:    []SFParam{{Key: "q", Value: 0.5}},
+			}},
+			{Key: "b", Item: SFItem{Value: true}},
+		}, sf.Dictionary)
+	})
+
+	t.Run("invalid values", func(t *testing.T) {
+		for _, input := range []string{`"unterminated`, "1,", "(1 2", "1234567890123456", "1.2345", "?2", ":notbase64", "a=1 b=2"} {
+			_, err := ParseStructuredField(input)
+			assert.Error(t, err, input)
+		}
+	})
+}
@@ -27,8 +27,14 @@ import (
 const (
 	// GRPCContentType is the MIME media type for grpc
 	GRPCContentType = "application/grpc"
+	// GRPCWebContentType is the MIME media type for grpc-web
+	GRPCWebContentType = "application/grpc-web"
+	// GRPCWebTextContentType is the MIME media type for base64 encoded grpc-web
+	GRPCWebTextContentType = "application/grpc-web-text"
 	// JSONContentType is the MIME media type for JSON
 	JSONContentType = "application/json"
+	// MessagePackContentType is the MIME media type for MessagePack
+	MessagePackContentType = "application/msgpack"
 	// ProtobufContentType is the MIME media type for Protobuf
 	ProtobufContentType = "application/x-protobuf"
 